@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"event-catcher-gateway/config"
+)
+
+// Supported node authentication modes
+const (
+	ModeNone  = "none"
+	ModeMTLS  = "mtls"
+	ModeToken = "token"
+)
+
+// ServerOptions returns the gRPC server options for the configured transport
+// security. Client certificates are verified when presented, so plain TLS
+// clients can still look up nodes while nodes authenticate with mTLS.
+func ServerOptions(cfg config.AuthConfig) ([]grpc.ServerOption, error) {
+	if cfg.TLS.CertFile == "" {
+		if cfg.Mode == ModeMTLS || cfg.Mode == ModeToken {
+			return nil, fmt.Errorf("auth mode %s requires auth.tls.cert_file and auth.tls.key_file", cfg.Mode)
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLS.CAFile != "" {
+		pool, err := loadCertPool(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else if cfg.Mode == ModeMTLS {
+		return nil, fmt.Errorf("auth mode %s requires auth.tls.ca_file", ModeMTLS)
+	}
+
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}, nil
+}
+
+// DialOptions returns the gRPC dial options for connecting to the gateway.
+// When nodeID is set and token authentication is configured, every RPC
+// carries a token for that node signed with auth.node_key. Tokens are bearer
+// credentials, so token authentication requires TLS.
+func DialOptions(cfg config.AuthConfig, nodeID string) ([]grpc.DialOption, error) {
+	secure := cfg.TLS.CAFile != ""
+
+	var opts []grpc.DialOption
+	if secure {
+		pool, err := loadCertPool(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig := &tls.Config{
+			RootCAs:    pool,
+			ServerName: cfg.TLS.ServerName,
+			MinVersion: tls.VersionTLS12,
+		}
+		if cfg.TLS.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if cfg.Mode == ModeToken && nodeID != "" {
+		if cfg.NodeKey == "" {
+			return nil, fmt.Errorf("auth mode %s requires auth.node_key", ModeToken)
+		}
+		if !secure {
+			return nil, fmt.Errorf("auth mode %s requires auth.tls.ca_file, tokens are not sent in plaintext", ModeToken)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(NewTokenCredentials(cfg.NodeKey, nodeID)))
+	}
+	return opts, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	return pool, nil
+}
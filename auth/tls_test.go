@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"testing"
+
+	"event-catcher-gateway/config"
+)
+
+func TestTokenModeRequiresTLS(t *testing.T) {
+	cfg := config.AuthConfig{Mode: ModeToken, TokenSecret: "gateway-secret", NodeKey: "node-key"}
+
+	if _, err := ServerOptions(cfg); err == nil {
+		t.Errorf("ServerOptions() without a server certificate = nil error, want an error")
+	}
+	if _, err := DialOptions(cfg, "node1"); err == nil {
+		t.Errorf("DialOptions() without a CA = nil error, want an error")
+	}
+	// Clients looking up nodes carry no token
+	if _, err := DialOptions(cfg, ""); err != nil {
+		t.Errorf("DialOptions() without a node ID = %v, want nil", err)
+	}
+}
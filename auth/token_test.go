@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("gateway-secret")
+	now := time.Unix(1_700_000_000, 0)
+	node1Key := []byte(NodeKey(secret, "node1"))
+	node2Key := []byte(NodeKey(secret, "node2"))
+
+	tests := []struct {
+		name       string
+		token      string
+		wantNodeID string
+		wantErr    bool
+	}{
+		{
+			name:       "valid",
+			token:      SignToken(node1Key, "node1", now),
+			wantNodeID: "node1",
+		},
+		{
+			name:       "node ID containing a colon",
+			token:      SignToken([]byte(NodeKey(secret, "rack:1")), "rack:1", now),
+			wantNodeID: "rack:1",
+		},
+		{
+			name:       "within skew",
+			token:      SignToken(node1Key, "node1", now.Add(-4*time.Minute)),
+			wantNodeID: "node1",
+		},
+		{
+			name:    "signed with another node's key",
+			token:   SignToken(node2Key, "node1", now),
+			wantErr: true,
+		},
+		{
+			name:    "signed with the gateway secret",
+			token:   SignToken(secret, "node1", now),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   SignToken(node1Key, "node1", now.Add(-6*time.Minute)),
+			wantErr: true,
+		},
+		{
+			name:    "issued in the future",
+			token:   SignToken(node1Key, "node1", now.Add(6*time.Minute)),
+			wantErr: true,
+		},
+		{
+			name:    "tampered node ID",
+			token:   "node2" + SignToken(node1Key, "node1", now)[len("node1"):],
+			wantErr: true,
+		},
+		{name: "empty", token: "", wantErr: true},
+		{name: "no timestamp", token: "node1:abc", wantErr: true},
+		{name: "bad timestamp", token: "node1:soon:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeID, err := VerifyToken(secret, tt.token, 5*time.Minute, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyToken() error = %v, want error %v", err, tt.wantErr)
+			}
+			if nodeID != tt.wantNodeID {
+				t.Errorf("VerifyToken() = %q, want %q", nodeID, tt.wantNodeID)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenRejectsForgedSignature(t *testing.T) {
+	secret := []byte("gateway-secret")
+	now := time.Now()
+
+	_, err := VerifyToken(secret, SignToken([]byte("guessed"), "node1", now), time.Minute, now)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyToken() error = %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/offsetstore"
+)
+
+// Timeout of the final commit made when a stream stops
+const finalCommitTimeout = 5 * time.Second
+
+// CheckpointStore keeps the position of each consumer in each data ID. The
+// stores of package offsetstore implement it.
+type CheckpointStore = offsetstore.Store
+
+// NewCheckpointStore creates the checkpoint store configured in cfg, or nil
+// when checkpointing is disabled
+func NewCheckpointStore(cfg *config.Config) (CheckpointStore, error) {
+	checkpoint := cfg.Client.Checkpoint
+	if checkpoint.Store == "" || checkpoint.Store == offsetstore.StoreNone {
+		return nil, nil
+	}
+	return offsetstore.New(checkpoint.Store, checkpoint.Dir, cfg.GetConsulAddr(), checkpoint.KVPrefix)
+}
+
+// checkpointer commits the position of one stream every N events or every
+// interval, whichever comes first
+type checkpointer struct {
+	store    CheckpointStore
+	consumer string
+	dataID   string
+	every    int
+
+	// Serializes commits, so an older position is never saved last
+	commitMu  sync.Mutex
+	committed int64
+
+	mu      sync.Mutex
+	offset  int64
+	pending int
+}
+
+func newCheckpointer(store CheckpointStore, consumer, dataID string, every int, offset int64) *checkpointer {
+	return &checkpointer{
+		store:     store,
+		consumer:  consumer,
+		dataID:    dataID,
+		every:     every,
+		offset:    offset,
+		committed: offset,
+	}
+}
+
+// processed records that the event at offset was processed and commits
+// once enough events are pending
+func (c *checkpointer) processed(ctx context.Context, offset int64) error {
+	c.mu.Lock()
+	c.offset = offset + 1
+	c.pending++
+	due := c.every > 0 && c.pending >= c.every
+	c.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	return c.commit(ctx)
+}
+
+// commit saves the current position if it changed since the last commit.
+// The position is saved without holding mu, so events keep being recorded
+// while the store is slow.
+func (c *checkpointer) commit(ctx context.Context) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	c.mu.Lock()
+	offset, pending := c.offset, c.pending
+	c.mu.Unlock()
+
+	if offset == c.committed {
+		return nil
+	}
+	if err := c.store.Save(ctx, c.consumer, c.dataID, offset); err != nil {
+		return fmt.Errorf("failed to commit checkpoint of data ID %s: %w", c.dataID, err)
+	}
+
+	c.committed = offset
+	c.mu.Lock()
+	c.pending -= pending
+	c.mu.Unlock()
+	return nil
+}
+
+// run commits the position every interval until the context is cancelled
+func (c *checkpointer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.commit(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
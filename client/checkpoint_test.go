@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"event-catcher-gateway/offsetstore"
+)
+
+// recordingStore records every position saved. Saves block while release
+// is set and not yet closed.
+type recordingStore struct {
+	offsetstore.Store
+	release chan struct{}
+
+	mu    sync.Mutex
+	saves []int64
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{Store: offsetstore.NewMemoryStore()}
+}
+
+func (s *recordingStore) Save(ctx context.Context, consumer, dataID string, offset int64) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	s.saves = append(s.saves, offset)
+	s.mu.Unlock()
+	return s.Store.Save(ctx, consumer, dataID, offset)
+}
+
+func (s *recordingStore) saved() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.saves)
+}
+
+func TestCheckpointerCommitsEveryNEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		every  int
+		events int64
+		want   []int64
+	}{
+		{name: "every event", every: 1, events: 3, want: []int64{1, 2, 3}},
+		{name: "every 3 events", every: 3, events: 7, want: []int64{3, 6}},
+		{name: "fewer events than N", every: 10, events: 5, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newRecordingStore()
+			cp := newCheckpointer(store, "consumer", "test-data", tt.every, 0)
+			for offset := int64(0); offset < tt.events; offset++ {
+				if err := cp.processed(context.Background(), offset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := store.saved(); !slices.Equal(got, tt.want) {
+				t.Errorf("saved %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckpointerCommitsEveryInterval(t *testing.T) {
+	store := newRecordingStore()
+	cp := newCheckpointer(store, "consumer", "test-data", 100, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cp.run(ctx, 10*time.Millisecond)
+
+	for offset := int64(0); offset < 5; offset++ {
+		if err := cp.processed(ctx, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.saved()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no commit within 5s of an interval of 10ms")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// An unchanged position isn't saved again
+	time.Sleep(50 * time.Millisecond)
+	if got := store.saved(); !slices.Equal(got, []int64{5}) {
+		t.Errorf("saved %v, want [5]", got)
+	}
+}
+
+func TestCheckpointerRecordsEventsDuringSlowCommit(t *testing.T) {
+	store := newRecordingStore()
+	store.release = make(chan struct{})
+	cp := newCheckpointer(store, "consumer", "test-data", 100, 0)
+	ctx := context.Background()
+
+	if err := cp.processed(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	committed := make(chan error, 1)
+	go func() { committed <- cp.commit(ctx) }()
+
+	// The commit is blocked in Save, events are still recorded
+	recorded := make(chan error, 1)
+	go func() { recorded <- cp.processed(ctx, 1) }()
+	select {
+	case err := <-recorded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processed() blocked on a commit in progress")
+	}
+
+	close(store.release)
+	if err := <-committed; err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.saved(); got[len(got)-1] != 2 {
+		t.Errorf("saved %v, want the last save at 2", got)
+	}
+}
+
+func TestStreamCommitsCheckpointOnStop(t *testing.T) {
+	store := newRecordingStore()
+	node := &fakeNode{id: "node-a", events: 10}
+	c := newTestClient(t, Options{
+		Checkpoints:        store,
+		Consumer:           "consumer",
+		CheckpointEvery:    100,
+		CheckpointInterval: time.Hour,
+	}, node)
+
+	// Neither N events nor the interval are reached, only the final commit
+	// saves the position after the last event handled
+	collect(t, c, 0, 5)
+	if got := store.saved(); !slices.Equal(got, []int64{4}) {
+		t.Errorf("saved %v, want [4]", got)
+	}
+
+	// A new stream resumes from that position
+	if got := collect(t, c, 0, 1); got[0] != 4 {
+		t.Errorf("resumed stream started at offset %d, want 4", got[0])
+	}
+}
@@ -0,0 +1,392 @@
+// Package client streams the events of a data ID from the nodes serving it.
+// It resolves a node through the gateway, remembers the offset of the last
+// event received and, when the stream fails, resolves a node again and
+// resumes right after that event.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/discovery"
+	pb "event-catcher-gateway/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults for the reconnect backoff and checkpoint commits
+const (
+	DefaultInitialBackoff     = 100 * time.Millisecond
+	DefaultMaxBackoff         = 10 * time.Second
+	DefaultCheckpointEvery    = 100
+	DefaultCheckpointInterval = 5 * time.Second
+)
+
+// Event is one event received from a node
+type Event struct {
+	DataID    string
+	Offset    int64
+	Timestamp time.Time
+	Data      []byte
+	// Node the event was received from
+	NodeID string
+}
+
+// Handler processes a received event. Returning an error stops the stream
+// and Stream returns that error.
+type Handler func(Event) error
+
+// Options configures a Client
+type Options struct {
+	// Key passed to the gateway for consistent hashing node selection
+	ClientKey string
+	// Options used to dial the gateway. Defaults to an insecure connection.
+	GatewayDialOptions []grpc.DialOption
+	// Options used to dial nodes. Defaults to an insecure connection.
+	NodeDialOptions []grpc.DialOption
+	// Delay before the first reconnect, doubled after each failed attempt
+	// up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Number of consecutive failed attempts after which Stream gives up,
+	// 0 to retry until the context is cancelled
+	MaxAttempts int
+	// Where the position of each stream is checkpointed, nil to not
+	// checkpoint. With a store, Stream starts from the stored position
+	// when there is one.
+	Checkpoints CheckpointStore
+	// Name under which checkpoints are stored, required with Checkpoints
+	Consumer string
+	// Commit a checkpoint after this many events or this long, whichever
+	// comes first
+	CheckpointEvery    int
+	CheckpointInterval time.Duration
+	// Consumer group whose offsets are committed to the nodes instead of a
+	// CheckpointStore. Stream starts after the group's committed offset
+	// when there is one, and commits like it checkpoints.
+	ConsumerGroup string
+}
+
+// Client streams events through the gateway
+type Client struct {
+	opts        Options
+	gatewayConn *grpc.ClientConn
+	gateway     pb.GatewayClient
+}
+
+// New creates a client using the gateway at gatewayAddr, which may be a
+// consul:// target resolving to several gateways
+func New(gatewayAddr string, opts Options) (*Client, error) {
+	if len(opts.GatewayDialOptions) == 0 {
+		opts.GatewayDialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	if len(opts.NodeDialOptions) == 0 {
+		opts.NodeDialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultInitialBackoff
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.InitialBackoff)
+	}
+	if opts.Checkpoints != nil && opts.Consumer == "" {
+		return nil, fmt.Errorf("a consumer name is required to checkpoint")
+	}
+	if opts.Checkpoints != nil && opts.ConsumerGroup != "" {
+		return nil, fmt.Errorf("checkpoints and consumer groups can't be used together")
+	}
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = DefaultCheckpointEvery
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	// Spread lookups over all gateways when the address resolves to several,
+	// as consul:// targets of the discovery package do
+	dialOpts := append([]grpc.DialOption{discovery.LoadBalancing()}, opts.GatewayDialOptions...)
+	conn, err := grpc.Dial(gatewayAddr, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+
+	return &Client{
+		opts:        opts,
+		gatewayConn: conn,
+		gateway:     pb.NewGatewayClient(conn),
+	}, nil
+}
+
+// Close closes the connection to the gateway
+func (c *Client) Close() error {
+	return c.gatewayConn.Close()
+}
+
+// Stream delivers the events of a data ID to the handler, starting at the
+// given offset or at the checkpointed or committed position when there is
+// one. Failed streams are resumed from the offset following the last event
+// handled, on whichever node the gateway picks next. Stream returns when the
+// context is cancelled, the handler fails, the error is not retryable or
+// MaxAttempts consecutive attempts failed.
+func (c *Client) Stream(ctx context.Context, dataID string, offset int64, handler Handler) error {
+	node := &streamNode{}
+	defer node.close()
+
+	store, consumer := c.opts.Checkpoints, c.opts.Consumer
+	if c.opts.ConsumerGroup != "" {
+		store, consumer = groupOffsets{node}, c.opts.ConsumerGroup
+	}
+
+	if store != nil {
+		stored, ok, err := store.Load(ctx, consumer, dataID)
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint of data ID %s: %w", dataID, err)
+		}
+		if ok {
+			log.Printf("Resuming data ID %s from checkpoint at offset %d", dataID, stored)
+			offset = stored
+		}
+
+		cp := newCheckpointer(store, consumer, dataID, c.opts.CheckpointEvery, offset)
+		cpCtx, cancel := context.WithCancel(ctx)
+		go cp.run(cpCtx, c.opts.CheckpointInterval)
+		defer func() {
+			cancel()
+			// Commit what was processed so a restart doesn't replay it
+			commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalCommitTimeout)
+			defer cancel()
+			if err := cp.commit(commitCtx); err != nil {
+				log.Printf("%v", err)
+			}
+		}()
+
+		process := handler
+		handler = func(event Event) error {
+			if err := process(event); err != nil {
+				return err
+			}
+			// A failed commit is retried with the next one, the stream goes on
+			if err := cp.processed(ctx, event.Offset); err != nil {
+				log.Printf("%v", err)
+			}
+			return nil
+		}
+	}
+
+	// The node picks the start of a consumer group until the first event
+	// was handled, from then on the stream resumes after that event
+	group := c.opts.ConsumerGroup
+	attempts := 0
+	backoff := c.opts.InitialBackoff
+	for {
+		next, err := c.streamOnce(ctx, dataID, offset, group, node, handler)
+		if next > offset {
+			// Progress was made, so the next failure starts a new series
+			offset = next
+			group = ""
+			attempts = 0
+			backoff = c.opts.InitialBackoff
+		}
+
+		var handlerErr *handlerError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &handlerErr):
+			return handlerErr.err
+		case !retryable(err):
+			return err
+		}
+
+		attempts++
+		if c.opts.MaxAttempts > 0 && attempts >= c.opts.MaxAttempts {
+			return fmt.Errorf("giving up on data ID %s after %d attempts: %w", dataID, attempts, err)
+		}
+
+		// Full jitter keeps clients of a failed node from reconnecting in lockstep
+		delay := rand.N(backoff) + 1
+		log.Printf("Stream of data ID %s failed, resuming from offset %d in %v: %v", dataID, offset, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, c.opts.MaxBackoff)
+	}
+}
+
+// handlerError wraps an error returned by the handler
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string { return e.err.Error() }
+
+// streamOnce resolves a node and streams from it until the stream fails.
+// The connection is kept in node for commits, until the next stream
+// replaces it. It returns the offset following the last event handled.
+func (c *Client) streamOnce(ctx context.Context, dataID string, offset int64, group string, node *streamNode, handler Handler) (int64, error) {
+	nodeResp, err := c.gateway.GetNodeForData(ctx, &pb.GetNodeRequest{
+		DataId:    dataID,
+		ClientKey: c.opts.ClientKey,
+	})
+	if err != nil {
+		return offset, fmt.Errorf("failed to get node for data ID %s: %w", dataID, err)
+	}
+
+	conn, err := grpc.Dial(nodeResp.NodeAddress, c.opts.NodeDialOptions...)
+	if err != nil {
+		return offset, fmt.Errorf("failed to connect to node %s: %w", nodeResp.NodeId, err)
+	}
+	node.set(conn, nodeResp.NodeId)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := pb.NewNodeClient(conn).StreamData(ctx, &pb.StreamRequest{
+		DataId:        dataID,
+		Offset:        offset,
+		ConsumerGroup: group,
+	})
+	if err != nil {
+		return offset, fmt.Errorf("failed to stream from node %s: %w", nodeResp.NodeId, err)
+	}
+
+	log.Printf("Streaming data ID %s from node %s at %s, offset %d",
+		dataID, nodeResp.NodeId, nodeResp.NodeAddress, offset)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return offset, fmt.Errorf("node %s ended the stream", nodeResp.NodeId)
+		}
+		if err != nil {
+			return offset, fmt.Errorf("stream from node %s failed: %w", nodeResp.NodeId, err)
+		}
+		// A node may resend events after a failover, skip those already handled
+		if chunk.Offset < offset {
+			continue
+		}
+
+		if err := handler(Event{
+			DataID:    chunk.DataId,
+			Offset:    chunk.Offset,
+			Timestamp: time.Unix(chunk.Timestamp, 0),
+			Data:      chunk.Data,
+			NodeID:    nodeResp.NodeId,
+		}); err != nil {
+			return offset, &handlerError{err: err}
+		}
+		offset = chunk.Offset + 1
+	}
+}
+
+// CommitOffset records that the consumer group of the client processed the
+// events of a data ID up to and including offset, on a node serving it.
+// Stream commits on its own to the node serving it, this is for commits
+// made outside of a stream, which need the nodes to share their offset store.
+func (c *Client) CommitOffset(ctx context.Context, dataID string, offset int64) error {
+	if c.opts.ConsumerGroup == "" {
+		return fmt.Errorf("no consumer group configured")
+	}
+
+	nodeResp, err := c.gateway.GetNodeForData(ctx, &pb.GetNodeRequest{
+		DataId:    dataID,
+		ClientKey: c.opts.ClientKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get node for data ID %s: %w", dataID, err)
+	}
+
+	conn, err := grpc.Dial(nodeResp.NodeAddress, c.opts.NodeDialOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to node %s: %w", nodeResp.NodeId, err)
+	}
+	defer conn.Close()
+
+	_, err = pb.NewNodeClient(conn).CommitOffset(ctx, &pb.CommitOffsetRequest{
+		DataId:        dataID,
+		ConsumerGroup: c.opts.ConsumerGroup,
+		Offset:        offset,
+	})
+	return err
+}
+
+// streamNode holds the connection to the node serving a stream
+type streamNode struct {
+	mu     sync.Mutex
+	conn   *grpc.ClientConn
+	nodeID string
+}
+
+// set replaces the connection, closing the previous one
+func (n *streamNode) set(conn *grpc.ClientConn, nodeID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.conn, n.nodeID = conn, nodeID
+}
+
+func (n *streamNode) close() {
+	n.set(nil, "")
+}
+
+// commitOffset commits a consumer group offset to the node
+func (n *streamNode) commitOffset(ctx context.Context, dataID, group string, offset int64) error {
+	n.mu.Lock()
+	conn, nodeID := n.conn, n.nodeID
+	n.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("no node is serving data ID %s", dataID)
+	}
+	_, err := pb.NewNodeClient(conn).CommitOffset(ctx, &pb.CommitOffsetRequest{
+		DataId:        dataID,
+		ConsumerGroup: group,
+		Offset:        offset,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit offset to node %s: %w", nodeID, err)
+	}
+	return nil
+}
+
+// groupOffsets commits checkpoints as consumer group offsets to the node
+// that served the events, since each node only accepts offsets it has
+// appended. Loading always comes up empty since nodes resume consumer
+// groups themselves.
+type groupOffsets struct {
+	node *streamNode
+}
+
+func (g groupOffsets) Load(ctx context.Context, consumer, dataID string) (int64, bool, error) {
+	return 0, false, nil
+}
+
+func (g groupOffsets) Save(ctx context.Context, consumer, dataID string, offset int64) error {
+	// Checkpoints are one past the last event processed
+	return g.node.commitOffset(ctx, dataID, consumer, offset-1)
+}
+
+// retryable reports whether a failed stream is worth resuming. Errors that
+// would fail the same way on every node are not.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange, codes.Unauthenticated,
+		codes.PermissionDenied, codes.Unimplemented:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/offsetstore"
+	pb "event-catcher-gateway/proto"
+)
+
+// serve starts a gRPC server on a loopback port and returns its address
+func serve(t *testing.T, register func(s *grpc.Server)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// fakeGateway hands out its nodes in turn
+type fakeGateway struct {
+	pb.UnimplementedGatewayServer
+	mu    sync.Mutex
+	nodes []*fakeNode
+	calls int
+}
+
+func (g *fakeGateway) GetNodeForData(ctx context.Context, req *pb.GetNodeRequest) (*pb.GetNodeResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node := g.nodes[g.calls%len(g.nodes)]
+	g.calls++
+	return &pb.GetNodeResponse{NodeId: node.id, NodeAddress: node.addr}, nil
+}
+
+// fakeNode serves the events 0 to events-1 of every data ID
+type fakeNode struct {
+	pb.UnimplementedNodeServer
+	id     string
+	addr   string
+	events int64
+	// Number of events after which streams fail, 0 to never fail
+	dropAfter int
+	offsets   offsetstore.Store
+
+	mu      sync.Mutex
+	starts  []int64
+	commits int
+}
+
+func (n *fakeNode) StreamData(req *pb.StreamRequest, stream pb.Node_StreamDataServer) error {
+	offset := req.Offset
+	if req.ConsumerGroup != "" {
+		stored, ok, err := n.offsets.Load(stream.Context(), req.ConsumerGroup, req.DataId)
+		if err != nil {
+			return err
+		}
+		if ok {
+			offset = stored
+		}
+	}
+	n.mu.Lock()
+	n.starts = append(n.starts, offset)
+	n.mu.Unlock()
+
+	sent := 0
+	for ; offset < n.events; offset++ {
+		if n.dropAfter > 0 && sent == n.dropAfter {
+			return status.Error(codes.Unavailable, "node dropped the stream")
+		}
+		if err := stream.Send(&pb.DataChunk{DataId: req.DataId, Offset: offset, Data: []byte(fmt.Sprint(offset))}); err != nil {
+			return err
+		}
+		sent++
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (n *fakeNode) CommitOffset(ctx context.Context, req *pb.CommitOffsetRequest) (*pb.CommitOffsetResponse, error) {
+	n.mu.Lock()
+	n.commits++
+	n.mu.Unlock()
+	if err := n.offsets.Save(ctx, req.ConsumerGroup, req.DataId, req.Offset+1); err != nil {
+		return nil, err
+	}
+	return &pb.CommitOffsetResponse{Success: true}, nil
+}
+
+// newTestClient starts the nodes and a gateway handing them out in turn
+func newTestClient(t *testing.T, opts Options, nodes ...*fakeNode) *Client {
+	t.Helper()
+	for _, node := range nodes {
+		node.addr = serve(t, func(s *grpc.Server) { pb.RegisterNodeServer(s, node) })
+	}
+	gatewayAddr := serve(t, func(s *grpc.Server) { pb.RegisterGatewayServer(s, &fakeGateway{nodes: nodes}) })
+
+	c, err := New(gatewayAddr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+var errStop = errors.New("stop")
+
+// collect streams until the handler received count events and returns
+// their offsets
+func collect(t *testing.T, c *Client, offset int64, count int) []int64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var offsets []int64
+	err := c.Stream(ctx, "test-data", offset, func(event Event) error {
+		offsets = append(offsets, event.Offset)
+		if len(offsets) == count {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Stream() = %v, want the handler's error", err)
+	}
+	return offsets
+}
+
+func TestConsumerGroupCommitsToServingNodeAndResumes(t *testing.T) {
+	// The nodes share their offsets, as with the consul store
+	offsets := offsetstore.NewMemoryStore()
+	nodeA := &fakeNode{id: "node-a", events: 10, offsets: offsets}
+	nodeB := &fakeNode{id: "node-b", events: 10, offsets: offsets}
+	c := newTestClient(t, Options{ConsumerGroup: "group", CheckpointEvery: 1}, nodeA, nodeB)
+
+	// The first stream is served by node A, which must get every commit
+	// even though the gateway hands out node B next
+	if got := collect(t, c, 0, 5); got[len(got)-1] != 4 {
+		t.Fatalf("first stream ended at offset %d, want 4", got[len(got)-1])
+	}
+	if nodeA.commits == 0 || nodeB.commits != 0 {
+		t.Errorf("commits = node A %d, node B %d, want all on node A", nodeA.commits, nodeB.commits)
+	}
+
+	// A restarted client is served by node B and resumes after the last
+	// event handled, the one the handler failed on is delivered again
+	c = newTestClient(t, Options{ConsumerGroup: "group", CheckpointEvery: 1}, nodeB)
+	if got := collect(t, c, 0, 1); got[0] != 4 {
+		t.Errorf("resumed stream started at offset %d, want 4", got[0])
+	}
+}
+
+func TestStreamResumesAfterDrops(t *testing.T) {
+	nodeA := &fakeNode{id: "node-a", events: 10, dropAfter: 3}
+	nodeB := &fakeNode{id: "node-b", events: 10, dropAfter: 3}
+	c := newTestClient(t, Options{InitialBackoff: time.Millisecond}, nodeA, nodeB)
+
+	// Every event is handled once and in order across the reconnects
+	got := collect(t, c, 0, 10)
+	want := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !slices.Equal(got, want) {
+		t.Errorf("handled offsets %v, want %v", got, want)
+	}
+
+	// Each stream starts right after the last event handled, on the node
+	// the gateway hands out next
+	if !slices.Equal(nodeA.starts, []int64{0, 6}) || !slices.Equal(nodeB.starts, []int64{3, 9}) {
+		t.Errorf("streams started at node A %v, node B %v, want [0 6] and [3 9]", nodeA.starts, nodeB.starts)
+	}
+}
+
+func TestStreamResetsBackoffAfterProgress(t *testing.T) {
+	// Every stream drops after one event. Two attempts without progress
+	// would give up, and a backoff doubling on every drop would add up to
+	// seconds.
+	node := &fakeNode{id: "node-a", events: 10, dropAfter: 1}
+	c := newTestClient(t, Options{
+		InitialBackoff: 20 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		MaxAttempts:    2,
+	}, node)
+
+	start := time.Now()
+	if got := collect(t, c, 0, 10); len(got) != 10 {
+		t.Fatalf("handled %d events, want 10", len(got))
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("10 reconnects took %v, want the backoff reset after each event", elapsed)
+	}
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"event-catcher-gateway/auth"
 	"event-catcher-gateway/config"
 	"event-catcher-gateway/gateway"
+	"event-catcher-gateway/healthcheck"
 	pb "event-catcher-gateway/proto"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
@@ -42,25 +53,136 @@ func runGateway(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create the gateway service
-	gatewayService, err := gateway.NewService(cfg.GetConsulAddr(), cfg.Consul.KVPrefix)
+	gatewayService, err := gateway.NewService(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create gateway service: %v", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start background tasks such as the whitelist watch
+	gatewayService.Start(ctx)
+
 	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	serverOpts, err := auth.ServerOptions(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to configure transport security: %v", err)
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
 	pb.RegisterGatewayServer(grpcServer, gatewayService)
 
+	// Report health over the standard gRPC health checking protocol, which
+	// the Consul check of the gateway's registration probes. The gateway is
+	// not serving while its registry backend is unreachable.
+	checkInterval, err := time.ParseDuration(cfg.Gateway.Registration.CheckInterval)
+	if err != nil || checkInterval <= 0 {
+		log.Fatalf("Invalid health check interval: %q", cfg.Gateway.Registration.CheckInterval)
+	}
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	healthcheck.Update(healthServer, pb.Gateway_ServiceDesc.ServiceName, gatewayService.Check)
+	go healthcheck.Watch(ctx, healthServer, pb.Gateway_ServiceDesc.ServiceName, checkInterval, gatewayService.Check)
+
 	// Start listening
 	lis, err := net.Listen("tcp", cfg.GetGatewayAddr())
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	log.Printf("Gateway service listening on %s", cfg.GetGatewayAddr())
-	if err := grpcServer.Serve(lis); err != nil {
+	// Register with Consul so nodes and clients can discover the gateway
+	var consulClient *api.Client
+	registration := gatewayRegistration(cfg)
+	if cfg.Gateway.Registration.Enabled {
+		consulConfig := api.DefaultConfig()
+		consulConfig.Address = cfg.GetConsulAddr()
+		consulClient, err = api.NewClient(consulConfig)
+		if err != nil {
+			log.Fatalf("Failed to create Consul client: %v", err)
+		}
+		if err := consulClient.Agent().ServiceRegister(registration); err != nil {
+			log.Fatalf("Failed to register service: %v", err)
+		}
+		log.Printf("Successfully registered service with Consul: %s", registration.ID)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(cfg.Gateway.ShutdownTimeout)
+	if err != nil {
+		log.Fatalf("Invalid shutdown timeout: %v", err)
+	}
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Gateway service listening on %s", cfg.GetGatewayAddr())
+		serveErr <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case sig := <-sigCh:
+		log.Printf("Received %v, shutting down...", sig)
+	case err := <-serveErr:
 		log.Fatalf("Failed to serve: %v", err)
 	}
 
+	// Take the gateway out of discovery first so new lookups go elsewhere
+	healthServer.Shutdown()
+	if consulClient != nil {
+		if err := consulClient.Agent().ServiceDeregister(registration.ID); err != nil {
+			log.Printf("Failed to deregister service: %v", err)
+		}
+	}
+
+	// Stop accepting connections and let in-flight RPCs finish, keeping the
+	// background tasks running so lookups stay correct while draining
+	stopGracefully(grpcServer, shutdownTimeout)
+	cancel()
+
+	log.Println("Gateway service stopped")
 	return nil
 }
+
+// gatewayRegistration builds the Consul registration of the gateway, checked
+// over the gRPC health checking protocol
+func gatewayRegistration(cfg *config.Config) *api.AgentServiceRegistration {
+	reg := cfg.Gateway.Registration
+	id := reg.ID
+	if id == "" {
+		id = fmt.Sprintf("%s-%s-%d", reg.ServiceName, reg.Address, cfg.Gateway.Port)
+	}
+
+	return &api.AgentServiceRegistration{
+		ID:      id,
+		Name:    reg.ServiceName,
+		Port:    cfg.Gateway.Port,
+		Address: reg.Address,
+		Check: &api.AgentServiceCheck{
+			GRPC:          fmt.Sprintf("%s/%s", net.JoinHostPort(reg.Address, strconv.Itoa(cfg.Gateway.Port)), pb.Gateway_ServiceDesc.ServiceName),
+			GRPCUseTLS:    cfg.Auth.TLS.CertFile != "",
+			TLSServerName: cfg.Auth.TLS.ServerName,
+			Interval:      reg.CheckInterval,
+			Timeout:       reg.CheckTimeout,
+		},
+		Tags: []string{"streaming", "gateway"},
+	}
+}
+
+// stopGracefully stops the server once in-flight RPCs finish, or cancels
+// them when they are still running after the timeout
+func stopGracefully(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("In-flight RPCs still running after %v, stopping anyway", timeout)
+		server.Stop()
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -9,51 +10,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"event-catcher-gateway/auth"
 	"event-catcher-gateway/config"
+	"event-catcher-gateway/discovery"
+	"event-catcher-gateway/gateway"
+	"event-catcher-gateway/healthcheck"
+	"event-catcher-gateway/node"
 	pb "event-catcher-gateway/proto"
 
 	"github.com/hashicorp/consul/api"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
 	configPath = flag.String("config", "config/config.yaml", "path to config file")
 )
 
-type nodeServer struct {
-	pb.UnimplementedNodeServer
-	nodeID string
-}
-
-func (s *nodeServer) StreamData(req *pb.StreamRequest, stream pb.Node_StreamDataServer) error {
-	log.Printf("Starting data stream for data ID: %s from offset: %d", req.DataId, req.Offset)
-
-	// Simulate streaming data
-	for i := req.Offset; ; i++ {
-		// Create a data chunk
-		chunk := &pb.DataChunk{
-			Data:      []byte(fmt.Sprintf("Data chunk %d for %s", i, req.DataId)),
-			Offset:    i,
-			Timestamp: time.Now().Unix(),
-			DataId:    req.DataId,
-		}
-
-		fmt.Println("Sending chunk:", chunk)
-
-		// Send the chunk
-		if err := stream.Send(chunk); err != nil {
-			return err
-		}
-
-		// Simulate some processing time
-		time.Sleep(100 * time.Millisecond)
-	}
-}
-
 func main() {
 	flag.Parse()
 
@@ -69,18 +47,69 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
+	// Open the event sources served by this node
+	sourceFactory, err := node.NewSourceFactory(cfg.Node.Source)
+	if err != nil {
+		log.Fatalf("Failed to configure event source: %v", err)
+	}
+	sources := node.NewSources(sourceFactory)
+	defer sources.Close()
+
+	// Resolve the data IDs served. Patterns match the data IDs stored by the
+	// node and those published to it.
+	served, err := node.NewServedDataIDs(cfg.Node, func() ([]string, error) {
+		stored, err := node.StoredDataIDs(cfg.Node.Source)
+		return append(stored, sources.DataIDs()...), err
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure data IDs: %v", err)
+	}
+
 	grpcServer := grpc.NewServer()
-	nodeServer := &nodeServer{nodeID: cfg.Node.ID}
-	pb.RegisterNodeServer(grpcServer, nodeServer)
+	offsets, err := node.NewOffsetStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create offset store: %v", err)
+	}
+	nodeService, err := node.NewService(cfg, sources, served, offsets)
+	if err != nil {
+		log.Fatalf("Failed to create node service: %v", err)
+	}
+	pb.RegisterNodeServer(grpcServer, nodeService)
+
+	// Report health over the standard gRPC health checking protocol, which
+	// the Consul check probes. The node is not serving while any of its
+	// event sources is down.
+	checkInterval, err := time.ParseDuration(cfg.Node.HealthCheck.Interval)
+	if err != nil || checkInterval <= 0 {
+		log.Fatalf("Invalid health check interval: %q", cfg.Node.HealthCheck.Interval)
+	}
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	healthcheck.Update(healthServer, pb.Node_ServiceDesc.ServiceName, nodeService.Check)
+
+	// Expose how far each stream is behind the head of its data ID
+	expvar.Publish("stream_lag", expvar.Func(func() any {
+		return nodeService.StreamLags()
+	}))
 
-	// Create HTTP server for health checks
+	// Create HTTP server for health checks and metrics
 	httpServer := &http.Server{
 		Addr: fmt.Sprintf(":%d", cfg.Node.HealthCheck.Port),
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == cfg.Node.HealthCheck.Path {
+			switch r.URL.Path {
+			case cfg.Node.HealthCheck.Path:
+				// Mirror the gRPC health status
+				resp, err := healthServer.Check(r.Context(), &healthpb.HealthCheckRequest{})
+				if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("NOT_SERVING"))
+					return
+				}
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte("OK"))
-			} else {
+			case "/debug/vars":
+				expvar.Handler().ServeHTTP(w, r)
+			default:
 				http.NotFound(w, r)
 			}
 		}),
@@ -105,61 +134,67 @@ func main() {
 		log.Fatalf("Failed to create Consul client: %v", err)
 	}
 
-	// Get the local IP address for the health check
-	localIP := "127.0.0.1" // Use localhost for Consul registration
-
-	// Log the IP address being used
-	log.Printf("Using IP for Consul registration: %s", localIP)
-
-	// Register service
-	registration := &api.AgentServiceRegistration{
-		ID:      cfg.Node.ID,
-		Name:    "streaming-node",
-		Port:    cfg.Node.Port,
-		Address: localIP,
-		Check: &api.AgentServiceCheck{
-			TCP:      fmt.Sprintf("%s:%d", localIP, cfg.Node.Port),
-			Interval: cfg.Node.HealthCheck.Interval,
-			Timeout:  cfg.Node.HealthCheck.Timeout,
-			Status:   "passing", // Set initial status to passing
-		},
-		Tags: []string{"streaming", "node"},
+	// Determine the address Consul, the gateway and clients reach the node at
+	advertiseAddr, err := node.AdvertiseAddress(cfg.Node)
+	if err != nil {
+		log.Fatalf("Failed to determine advertise address: %v", err)
 	}
+	log.Printf("Advertising node at %s", advertiseAddr)
 
+	// Register service. The check starts out critical, so nodes are only
+	// selected once Consul has seen them serve.
+	registration := serviceRegistration(cfg, advertiseAddr, 0)
+	registration.Check = serviceCheck(cfg, advertiseAddr)
 	if err := consulClient.Agent().ServiceRegister(registration); err != nil {
 		log.Fatalf("Failed to register service: %v", err)
 	}
 	log.Printf("Successfully registered service with Consul: %s", cfg.Node.ID)
 
 	// Register with the gateway service
-	gatewayAddr := cfg.GetGatewayAddr()
-	gatewayConn, err := grpc.Dial(gatewayAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	dialOpts, err := auth.DialOptions(cfg.Auth, cfg.Node.ID)
 	if err != nil {
-		log.Printf("Failed to connect to gateway: %v", err)
-	} else {
-		defer gatewayConn.Close()
-
-		// Create gateway client
-		gatewayClient := pb.NewGatewayClient(gatewayConn)
-
-		// Register with the gateway
-		ctx := context.Background()
-		resp, err := gatewayClient.RegisterNode(ctx, &pb.RegisterNodeRequest{
-			NodeId: cfg.Node.ID,
-			DataId: "test-data", // Default data ID, can be configured later
-		})
-
-		if err != nil {
-			log.Printf("Failed to register with gateway: %v", err)
-		} else {
-			log.Printf("Successfully registered with gateway: %s", resp.Message)
-		}
+		log.Fatalf("Failed to configure gateway credentials: %v", err)
+	}
+	gatewayConn, err := grpc.Dial(discovery.GatewayTarget(cfg), append(dialOpts, discovery.LoadBalancing())...)
+	if err != nil {
+		log.Fatalf("Failed to connect to gateway: %v", err)
+	}
+	defer gatewayConn.Close()
+
+	nodeAddr := net.JoinHostPort(advertiseAddr, strconv.Itoa(cfg.Node.Port))
+	registrar, err := node.NewRegistrar(pb.NewGatewayClient(gatewayConn), cfg.Node, nodeAddr, served)
+	if err != nil {
+		log.Fatalf("Failed to configure gateway registration: %v", err)
 	}
 
 	// Handle graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Keep the active stream count in the Consul registration up to date
+	go reportLoad(ctx, consulClient, cfg, advertiseAddr, nodeService)
+
+	// Keep the health status in line with the event sources
+	go healthcheck.Watch(ctx, healthServer, pb.Node_ServiceDesc.ServiceName, checkInterval, nodeService.Check)
+
+	// Register the data IDs served with the gateway in the background,
+	// retrying until it is reachable, and keep the registrations alive
+	go registrar.Run(ctx)
+
+	// Follow changes to the data IDs served without a restart
+	err = config.WatchConfig(*configPath, func(reloaded *config.Config) {
+		if err := served.Update(reloaded.Node); err != nil {
+			log.Printf("Ignoring reloaded data IDs: %v", err)
+			return
+		}
+		if err := registrar.Sync(ctx); err != nil {
+			log.Printf("%v", err)
+		}
+	})
+	if err != nil {
+		log.Printf("Not watching config file for changes: %v", err)
+	}
+
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -187,7 +222,9 @@ func main() {
 		log.Printf("Failed to shutdown HTTP server: %v", err)
 	}
 
-	// Shutdown gRPC server
+	// Shutdown gRPC server, ending the live streams first so it can drain
+	healthServer.Shutdown()
+	nodeService.Close()
 	grpcServer.GracefulStop()
 
 	// Deregister from Consul
@@ -195,3 +232,60 @@ func main() {
 		log.Printf("Failed to deregister service: %v", err)
 	}
 }
+
+// serviceRegistration builds the Consul registration for this node,
+// publishing its weight and current load as service metadata. It carries no
+// check: re-registering without one keeps the existing check and its status.
+func serviceRegistration(cfg *config.Config, addr string, activeStreams int64) *api.AgentServiceRegistration {
+	return &api.AgentServiceRegistration{
+		ID:      cfg.Node.ID,
+		Name:    "streaming-node",
+		Port:    cfg.Node.Port,
+		Address: addr,
+		Tags:    []string{"streaming", "node"},
+		Meta: map[string]string{
+			gateway.MetaWeight:        strconv.Itoa(cfg.Node.Weight),
+			gateway.MetaActiveStreams: strconv.FormatInt(activeStreams, 10),
+		},
+	}
+}
+
+// serviceCheck builds the gRPC health check Consul runs against this node
+func serviceCheck(cfg *config.Config, addr string) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		GRPC:     fmt.Sprintf("%s/%s", net.JoinHostPort(addr, strconv.Itoa(cfg.Node.Port)), pb.Node_ServiceDesc.ServiceName),
+		Interval: cfg.Node.HealthCheck.Interval,
+		Timeout:  cfg.Node.HealthCheck.Timeout,
+	}
+}
+
+// reportLoad periodically updates the service metadata in Consul whenever
+// the node's active stream count changes. The health check is left alone,
+// so a failing check stays failing.
+func reportLoad(ctx context.Context, consulClient *api.Client, cfg *config.Config, addr string, s *node.Service) {
+	interval, err := time.ParseDuration(cfg.Node.HealthCheck.Interval)
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reported int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := s.ActiveStreams()
+			if current == reported {
+				continue
+			}
+			if err := consulClient.Agent().ServiceRegister(serviceRegistration(cfg, addr, current)); err != nil {
+				log.Printf("Failed to report load to Consul: %v", err)
+				continue
+			}
+			reported = current
+		}
+	}
+}
@@ -3,8 +3,11 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"strings"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
@@ -13,20 +16,173 @@ type Config struct {
 	Gateway GatewayConfig `mapstructure:"gateway"`
 	Node    NodeConfig    `mapstructure:"node"`
 	Consul  ConsulConfig  `mapstructure:"consul"`
+	Auth    AuthConfig    `mapstructure:"auth"`
+	Client  ClientConfig  `mapstructure:"client"`
 	Log     LogConfig     `mapstructure:"log"`
 }
 
+// ClientConfig holds the configuration of the client SDK
+type ClientConfig struct {
+	// Name under which the client's checkpoints are stored
+	Consumer   string           `mapstructure:"consumer"`
+	Checkpoint CheckpointConfig `mapstructure:"checkpoint"`
+}
+
+// CheckpointConfig holds the configuration of the client's offset
+// checkpoints. A checkpoint is committed after Every events or Interval,
+// whichever comes first.
+type CheckpointConfig struct {
+	Store    string `mapstructure:"store"`
+	Dir      string `mapstructure:"dir"`
+	KVPrefix string `mapstructure:"kv_prefix"`
+	Every    int    `mapstructure:"every"`
+	Interval string `mapstructure:"interval"`
+}
+
 // GatewayConfig holds gateway service configuration
 type GatewayConfig struct {
-	Host string `mapstructure:"host"`
-	Port int    `mapstructure:"port"`
+	Host      string          `mapstructure:"host"`
+	Port      int             `mapstructure:"port"`
+	Registry  string          `mapstructure:"registry"`
+	Selection SelectionConfig `mapstructure:"selection"`
+	Whitelist WhitelistConfig `mapstructure:"whitelist"`
+	Cache     CacheConfig     `mapstructure:"cache"`
+	Leases    LeaseConfig     `mapstructure:"leases"`
+	// How long in-flight RPCs may run after a shutdown signal
+	ShutdownTimeout string                    `mapstructure:"shutdown_timeout"`
+	Registration    GatewayRegistrationConfig `mapstructure:"registration"`
+	Discovery       GatewayDiscoveryConfig    `mapstructure:"discovery"`
+}
+
+// GatewayRegistrationConfig holds the configuration of the gateway's own
+// Consul service registration
+type GatewayRegistrationConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	ServiceName string `mapstructure:"service_name"`
+	// Service ID, defaults to the service name followed by the address
+	ID string `mapstructure:"id"`
+	// Address advertised to Consul, defaults to 127.0.0.1
+	Address       string `mapstructure:"address"`
+	CheckInterval string `mapstructure:"check_interval"`
+	CheckTimeout  string `mapstructure:"check_timeout"`
+}
+
+// GatewayDiscoveryConfig holds how nodes and clients find the gateway. When
+// enabled they resolve the healthy instances of the gateway's Consul service
+// and load-balance across them instead of dialing host and port.
+type GatewayDiscoveryConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// CacheConfig holds the configuration of the gateway's local cache of node
+// mappings and health. Lookups fall back to reading Consul directly when the
+// cache has not been refreshed within MaxStaleness.
+type CacheConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	MaxStaleness string `mapstructure:"max_staleness"`
+}
+
+// LeaseConfig holds the configuration of node leases. When enabled, a node's
+// registrations expire once it stops sending heartbeats for TTL.
+type LeaseConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	TTL      string `mapstructure:"ttl"`
+	KVPrefix string `mapstructure:"kv_prefix"`
+}
+
+// WhitelistConfig holds the node whitelist configuration. When KVPrefix is
+// set the whitelist is loaded from and kept in sync with Consul KV instead
+// of the static node list.
+type WhitelistConfig struct {
+	Nodes    []string `mapstructure:"nodes"`
+	KVPrefix string   `mapstructure:"kv_prefix"`
+}
+
+// SelectionConfig holds node selection strategy configuration
+type SelectionConfig struct {
+	Strategy  string              `mapstructure:"strategy"`
+	Overrides []SelectionOverride `mapstructure:"overrides"`
+}
+
+// SelectionOverride sets the selection strategy for a single data ID
+type SelectionOverride struct {
+	DataID   string `mapstructure:"data_id"`
+	Strategy string `mapstructure:"strategy"`
 }
 
 // NodeConfig holds node service configuration
 type NodeConfig struct {
-	ID          string            `mapstructure:"id"`
-	Port        int               `mapstructure:"port"`
-	HealthCheck HealthCheckConfig `mapstructure:"health_check"`
+	ID                 string                 `mapstructure:"id"`
+	Port               int                    `mapstructure:"port"`
+	Weight             int                    `mapstructure:"weight"`
+	Role               string                 `mapstructure:"role"`
+	Labels             map[string]string      `mapstructure:"labels"`
+	AdvertiseAddress   string                 `mapstructure:"advertise_address"`
+	AdvertiseInterface string                 `mapstructure:"advertise_interface"`
+	AdvertiseCIDR      string                 `mapstructure:"advertise_cidr"`
+	DataIDs            []string               `mapstructure:"data_ids"`
+	DataIDPatterns     []string               `mapstructure:"data_id_patterns"`
+	HeartbeatInterval  string                 `mapstructure:"heartbeat_interval"`
+	Registration       NodeRegistrationConfig `mapstructure:"registration"`
+	HealthCheck        HealthCheckConfig      `mapstructure:"health_check"`
+	Source             SourceConfig           `mapstructure:"source"`
+	Publish            PublishConfig          `mapstructure:"publish"`
+	Stream             StreamConfig           `mapstructure:"stream"`
+	Offsets            OffsetsConfig          `mapstructure:"offsets"`
+}
+
+// NodeRegistrationConfig holds how a node retries its registration with the
+// gateway and how often it checks the gateway still has it
+type NodeRegistrationConfig struct {
+	InitialBackoff    string `mapstructure:"initial_backoff"`
+	MaxBackoff        string `mapstructure:"max_backoff"`
+	ReconcileInterval string `mapstructure:"reconcile_interval"`
+}
+
+// OffsetsConfig holds the configuration of the store of consumer group
+// offsets committed to a node
+type OffsetsConfig struct {
+	Store    string `mapstructure:"store"`
+	Dir      string `mapstructure:"dir"`
+	KVPrefix string `mapstructure:"kv_prefix"`
+}
+
+// StreamConfig holds the configuration of the streams served by a node
+type StreamConfig struct {
+	BufferSize int              `mapstructure:"buffer_size"`
+	Policy     string           `mapstructure:"policy"`
+	MaxLag     int64            `mapstructure:"max_lag"`
+	Overrides  []StreamOverride `mapstructure:"overrides"`
+}
+
+// StreamOverride sets the slow-consumer policy for a single data ID
+type StreamOverride struct {
+	DataID string `mapstructure:"data_id"`
+	Policy string `mapstructure:"policy"`
+	MaxLag int64  `mapstructure:"max_lag"`
+}
+
+// PublishConfig holds the configuration of event publishing on a node
+type PublishConfig struct {
+	DedupWindow int    `mapstructure:"dedup_window"`
+	ProducerTTL string `mapstructure:"producer_ttl"`
+}
+
+// SourceConfig holds the configuration of the event sources a node serves
+type SourceConfig struct {
+	Type         string          `mapstructure:"type"`
+	Dir          string          `mapstructure:"dir"`
+	PollInterval string          `mapstructure:"poll_interval"`
+	RingSize     int             `mapstructure:"ring_size"`
+	Log          LogSourceConfig `mapstructure:"log"`
+}
+
+// LogSourceConfig holds the configuration of segmented log sources
+type LogSourceConfig struct {
+	SegmentBytes   int64  `mapstructure:"segment_bytes"`
+	RetentionBytes int64  `mapstructure:"retention_bytes"`
+	RetentionAge   string `mapstructure:"retention_age"`
+	Fsync          bool   `mapstructure:"fsync"`
 }
 
 // HealthCheckConfig holds health check configuration
@@ -44,6 +200,38 @@ type ConsulConfig struct {
 	KVPrefix string `mapstructure:"kv_prefix"`
 }
 
+// AuthConfig holds node authentication and transport security configuration
+type AuthConfig struct {
+	Mode         string    `mapstructure:"mode"`
+	TokenSecret  string    `mapstructure:"token_secret"`
+	NodeKey      string    `mapstructure:"node_key"`
+	AdminID      string    `mapstructure:"admin_id"`
+	TokenMaxSkew string    `mapstructure:"token_max_skew"`
+	TLS          TLSConfig `mapstructure:"tls"`
+}
+
+// String prints the configuration with the token secret and node key masked,
+// so they don't end up in logs
+func (c AuthConfig) String() string {
+	type plain AuthConfig
+	masked := plain(c)
+	for _, secret := range []*string{&masked.TokenSecret, &masked.NodeKey} {
+		if *secret != "" {
+			*secret = "<redacted>"
+		}
+	}
+	return fmt.Sprintf("%+v", masked)
+}
+
+// TLSConfig holds certificate configuration. The gateway uses it to serve TLS
+// and verify client certificates, nodes and clients use it to dial the gateway.
+type TLSConfig struct {
+	CAFile     string `mapstructure:"ca_file"`
+	CertFile   string `mapstructure:"cert_file"`
+	KeyFile    string `mapstructure:"key_file"`
+	ServerName string `mapstructure:"server_name"`
+}
+
 // LogConfig holds logging configuration
 type LogConfig struct {
 	Level  string `mapstructure:"level"`
@@ -52,6 +240,52 @@ type LogConfig struct {
 
 // LoadConfig loads configuration from file and environment variables
 func LoadConfig(configPath string) (*Config, error) {
+	v := newViper(configPath)
+
+	// Read the config file
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		log.Printf("No config file found, using defaults and environment variables")
+	} else {
+		log.Printf("Using config file: %s", v.ConfigFileUsed())
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+
+	// Log the loaded configuration
+	log.Printf("Loaded configuration: %+v", config)
+
+	return &config, nil
+}
+
+// WatchConfig calls onChange with the reloaded configuration every time the
+// config file changes. It fails when there is no config file to watch.
+func WatchConfig(configPath string, onChange func(*Config)) error {
+	v := newViper(configPath)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		var config Config
+		if err := v.Unmarshal(&config); err != nil {
+			log.Printf("Ignoring config change in %s: %v", e.Name, err)
+			return
+		}
+		log.Printf("Reloaded configuration from %s", e.Name)
+		onChange(&config)
+	})
+	v.WatchConfig()
+	return nil
+}
+
+// newViper creates a viper instance reading the config file and environment
+func newViper(configPath string) *viper.Viper {
 	v := viper.New()
 
 	// Set default values
@@ -75,25 +309,7 @@ func LoadConfig(configPath string) (*Config, error) {
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
-	// Read the config file
-	if err := v.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("error reading config file: %w", err)
-		}
-		log.Printf("No config file found, using defaults and environment variables")
-	} else {
-		log.Printf("Using config file: %s", v.ConfigFileUsed())
-	}
-
-	var config Config
-	if err := v.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("error unmarshaling config: %w", err)
-	}
-
-	// Log the loaded configuration
-	log.Printf("Loaded configuration: %+v", config)
-
-	return &config, nil
+	return v
 }
 
 // setDefaults sets default values for the configuration
@@ -101,20 +317,83 @@ func setDefaults(v *viper.Viper) {
 	// Gateway defaults
 	v.SetDefault("gateway.host", "0.0.0.0")
 	v.SetDefault("gateway.port", 50051)
+	v.SetDefault("gateway.registry", "consul")
+	v.SetDefault("gateway.selection.strategy", "round_robin")
+	v.SetDefault("gateway.whitelist.nodes", []string{"node1", "node2", "node3"})
+	v.SetDefault("gateway.whitelist.kv_prefix", "")
+	v.SetDefault("gateway.cache.enabled", false)
+	v.SetDefault("gateway.cache.max_staleness", "30s")
+	v.SetDefault("gateway.leases.enabled", false)
+	v.SetDefault("gateway.leases.ttl", "30s")
+	v.SetDefault("gateway.leases.kv_prefix", "streaming/leases/")
+	v.SetDefault("gateway.shutdown_timeout", "15s")
+	v.SetDefault("gateway.registration.enabled", false)
+	v.SetDefault("gateway.registration.service_name", "event-gateway")
+	v.SetDefault("gateway.registration.id", "")
+	v.SetDefault("gateway.registration.address", "127.0.0.1")
+	v.SetDefault("gateway.registration.check_interval", "10s")
+	v.SetDefault("gateway.registration.check_timeout", "5s")
+	v.SetDefault("gateway.discovery.enabled", false)
 
 	// Node defaults
 	v.SetDefault("node.id", "node1")
 	v.SetDefault("node.port", 50052)
+	v.SetDefault("node.weight", 1)
+	v.SetDefault("node.role", "primary")
+	v.SetDefault("node.advertise_address", "")
+	v.SetDefault("node.advertise_interface", "")
+	v.SetDefault("node.advertise_cidr", "")
+	v.SetDefault("node.data_ids", []string{"test-data"})
+	v.SetDefault("node.data_id_patterns", []string{})
+	v.SetDefault("node.heartbeat_interval", "10s")
+	v.SetDefault("node.registration.initial_backoff", "1s")
+	v.SetDefault("node.registration.max_backoff", "1m")
+	v.SetDefault("node.registration.reconcile_interval", "1m")
 	v.SetDefault("node.health_check.path", "/health")
 	v.SetDefault("node.health_check.port", 50053)
 	v.SetDefault("node.health_check.interval", "10s")
 	v.SetDefault("node.health_check.timeout", "5s")
+	v.SetDefault("node.source.type", "file")
+	v.SetDefault("node.source.dir", "data")
+	v.SetDefault("node.source.poll_interval", "200ms")
+	v.SetDefault("node.source.ring_size", 10000)
+	v.SetDefault("node.source.log.segment_bytes", 64<<20)
+	v.SetDefault("node.source.log.retention_bytes", 1<<30)
+	v.SetDefault("node.source.log.retention_age", "168h")
+	v.SetDefault("node.source.log.fsync", false)
+	v.SetDefault("node.publish.dedup_window", 10000)
+	v.SetDefault("node.publish.producer_ttl", "1h")
+	v.SetDefault("node.stream.buffer_size", 1024)
+	v.SetDefault("node.stream.policy", "block")
+	v.SetDefault("node.stream.max_lag", 100000)
+	v.SetDefault("node.offsets.store", "consul")
+	v.SetDefault("node.offsets.dir", "offsets")
+	v.SetDefault("node.offsets.kv_prefix", "streaming/offsets/")
 
 	// Consul defaults
 	v.SetDefault("consul.host", "localhost")
 	v.SetDefault("consul.port", 8500)
 	v.SetDefault("consul.kv_prefix", "streaming/data/")
 
+	// Auth defaults
+	v.SetDefault("auth.mode", "none")
+	v.SetDefault("auth.token_secret", "")
+	v.SetDefault("auth.node_key", "")
+	v.SetDefault("auth.token_max_skew", "5m")
+	v.SetDefault("auth.admin_id", "admin")
+	v.SetDefault("auth.tls.ca_file", "")
+	v.SetDefault("auth.tls.cert_file", "")
+	v.SetDefault("auth.tls.key_file", "")
+	v.SetDefault("auth.tls.server_name", "")
+
+	// Client defaults
+	v.SetDefault("client.consumer", "")
+	v.SetDefault("client.checkpoint.store", "none")
+	v.SetDefault("client.checkpoint.dir", "checkpoints")
+	v.SetDefault("client.checkpoint.kv_prefix", "streaming/checkpoints/")
+	v.SetDefault("client.checkpoint.every", 100)
+	v.SetDefault("client.checkpoint.interval", "5s")
+
 	// Log defaults
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.format", "text")
@@ -122,12 +401,12 @@ func setDefaults(v *viper.Viper) {
 
 // GetGatewayAddr returns the full gateway address
 func (c *Config) GetGatewayAddr() string {
-	return fmt.Sprintf("%s:%d", c.Gateway.Host, c.Gateway.Port)
+	return net.JoinHostPort(c.Gateway.Host, strconv.Itoa(c.Gateway.Port))
 }
 
 // GetConsulAddr returns the full Consul address
 func (c *Config) GetConsulAddr() string {
-	return fmt.Sprintf("%s:%d", c.Consul.Host, c.Consul.Port)
+	return net.JoinHostPort(c.Consul.Host, strconv.Itoa(c.Consul.Port))
 }
 
 // GetNodeAddr returns the full node address
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLoggedConfigMasksSecrets(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{Mode: "token", TokenSecret: "gateway-secret", NodeKey: "node-key"}}
+
+	logged := fmt.Sprintf("%+v", cfg)
+	for _, secret := range []string{"gateway-secret", "node-key"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("logged configuration contains %q: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "Mode:token") {
+		t.Errorf("logged configuration lacks the auth mode: %s", logged)
+	}
+}
@@ -0,0 +1,53 @@
+// Package consulwatch follows Consul state with blocking queries
+package consulwatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Maximum time a blocking query waits for changes before returning
+const MaxWaitTime = 5 * time.Minute
+
+// Delay before retrying a failed blocking query
+const retryDelay = 5 * time.Second
+
+// Watch runs a blocking query in a loop until the context is cancelled,
+// each query waiting up to waitTime for the index to move past the last one
+// seen. Every successful result is passed to update, with changed false when
+// the query returned because the wait time ran out rather than because the
+// state changed. Failed queries are passed to onError and retried after a
+// delay.
+func Watch[T any](ctx context.Context, waitTime time.Duration,
+	query func(opts *api.QueryOptions) (T, *api.QueryMeta, error),
+	update func(result T, changed bool), onError func(err error)) {
+	var waitIndex uint64
+	for {
+		opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: waitTime}).WithContext(ctx)
+		result, meta, err := query(opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			onError(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		// The index can go backwards when Consul state is restored,
+		// in which case the watch has to start over
+		if meta.LastIndex < waitIndex {
+			waitIndex = 0
+			continue
+		}
+		changed := meta.LastIndex != waitIndex
+		waitIndex = meta.LastIndex
+		update(result, changed)
+	}
+}
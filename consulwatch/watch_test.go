@@ -0,0 +1,56 @@
+package consulwatch
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestWatchFollowsIndex(t *testing.T) {
+	// Indexes returned by successive queries: a change, a timeout, a
+	// restored Consul state going backwards, and the state after it
+	indexes := []uint64{5, 5, 3, 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var waitIndexes []uint64
+	query := func(opts *api.QueryOptions) (uint64, *api.QueryMeta, error) {
+		waitIndexes = append(waitIndexes, opts.WaitIndex)
+		if len(waitIndexes) == len(indexes) {
+			cancel()
+		}
+		index := indexes[len(waitIndexes)-1]
+		return index, &api.QueryMeta{LastIndex: index}, nil
+	}
+
+	type result struct {
+		index   uint64
+		changed bool
+	}
+	var results []result
+	update := func(index uint64, changed bool) {
+		results = append(results, result{index, changed})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, time.Minute, query, update, func(err error) { t.Errorf("onError(%v)", err) })
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after the context was cancelled")
+	}
+
+	if want := []uint64{0, 5, 5, 0}; !slices.Equal(waitIndexes, want) {
+		t.Errorf("wait indexes = %v, want %v", waitIndexes, want)
+	}
+	if want := []result{{5, true}, {5, false}}; !slices.Equal(results, want) {
+		t.Errorf("updates = %v, want %v", results, want)
+	}
+}
@@ -0,0 +1,131 @@
+// Package discovery resolves gRPC targets of the form
+// consul://<consul address>/<service name> to the healthy instances of a
+// Consul service, so clients can load-balance across several gateways
+// registered under one logical name.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/consulwatch"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme of the targets resolved through Consul
+const Scheme = "consul"
+
+func init() {
+	resolver.Register(builder{})
+}
+
+// Target returns the gRPC target resolving to the healthy instances of a
+// Consul service
+func Target(consulAddr, service string) string {
+	return fmt.Sprintf("%s://%s/%s", Scheme, consulAddr, service)
+}
+
+// LoadBalancing returns the dial option spreading RPCs over all resolved
+// instances instead of sticking to the first one
+func LoadBalancing() grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`)
+}
+
+// builder creates resolvers for consul:// targets
+type builder struct{}
+
+func (builder) Scheme() string { return Scheme }
+
+func (builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	service := strings.TrimPrefix(target.URL.Path, "/")
+	if service == "" {
+		return nil, fmt.Errorf("no service name in target %s", target.URL.String())
+	}
+
+	consulConfig := api.DefaultConfig()
+	if target.URL.Host != "" {
+		consulConfig.Address = target.URL.Host
+	}
+	client, err := api.NewClient(consulConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Consul client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &consulResolver{
+		health:  client.Health(),
+		service: service,
+		cc:      cc,
+		cancel:  cancel,
+	}
+	go r.watch(ctx)
+	return r, nil
+}
+
+// consulResolver keeps the addresses of a service's passing instances up
+// to date using blocking queries
+type consulResolver struct {
+	health  *api.Health
+	service string
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+}
+
+func (r *consulResolver) watch(ctx context.Context) {
+	query := func(opts *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+		return r.health.Service(r.service, "", true, opts)
+	}
+	consulwatch.Watch(ctx, consulwatch.MaxWaitTime, query, r.update, func(err error) {
+		log.Printf("Failed to resolve service %s in Consul: %v", r.service, err)
+		r.cc.ReportError(err)
+	})
+}
+
+// update hands the addresses of the passing instances to gRPC
+func (r *consulResolver) update(entries []*api.ServiceEntry, changed bool) {
+	if !changed {
+		return
+	}
+
+	addresses := make([]resolver.Address, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addresses = append(addresses, resolver.Address{
+			Addr: net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)),
+		})
+	}
+	if len(addresses) == 0 {
+		r.cc.ReportError(fmt.Errorf("no healthy instances of service %s", r.service))
+		return
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addresses}); err != nil {
+		log.Printf("Failed to update addresses of service %s: %v", r.service, err)
+	}
+}
+
+// ResolveNow is a no-op, the watch picks up changes as they happen
+func (r *consulResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *consulResolver) Close() {
+	r.cancel()
+}
+
+// GatewayTarget returns the gRPC target of the gateway: its Consul service
+// when discovery is enabled, its configured address otherwise
+func GatewayTarget(cfg *config.Config) string {
+	if cfg.Gateway.Discovery.Enabled {
+		return Target(cfg.GetConsulAddr(), cfg.Gateway.Registration.ServiceName)
+	}
+	return cfg.GetGatewayAddr()
+}
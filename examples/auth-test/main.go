@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"event-catcher-gateway/auth"
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/discovery"
+	pb "event-catcher-gateway/proto"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	configPath  string
+	nodeID      string
+	dataID      string
+	credsNodeID string
+	rootCmd     = &cobra.Command{
+		Use:   "auth-test",
+		Short: "Event Catcher Node Authentication Test",
+		Long:  `Registers a node with the gateway using the configured credentials to check that node authentication accepts or rejects it.`,
+		RunE:  runAuthTest,
+	}
+	nodeKeyCmd = &cobra.Command{
+		Use:   "node-key",
+		Short: "Print the token key of a node",
+		Long:  `Derives the auth.node_key of the node given with --node-id from the gateway's auth.token_secret.`,
+		RunE:  runNodeKey,
+	}
+	unregisterCmd = &cobra.Command{
+		Use:   "unregister",
+		Short: "Unregister a node from a data ID",
+		Long:  `Unregisters the node given with --node-id from --data-id. Pass --token-node-id with auth.admin_id and the operator's credentials to remove another node, for instance to drain it.`,
+		RunE:  runUnregister,
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to config file")
+	rootCmd.PersistentFlags().StringVarP(&nodeID, "node-id", "n", "node1", "node ID to claim in the registration")
+	rootCmd.PersistentFlags().StringVarP(&dataID, "data-id", "d", "test-data", "data ID to register for")
+	rootCmd.PersistentFlags().StringVar(&credsNodeID, "token-node-id", "", "node ID to sign the token for with auth.node_key in token mode (default: --node-id)")
+	rootCmd.AddCommand(nodeKeyCmd, unregisterCmd)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+// dialGateway connects to the gateway with the credentials of --token-node-id
+func dialGateway(cfg *config.Config) (*grpc.ClientConn, error) {
+	if credsNodeID == "" {
+		credsNodeID = nodeID
+	}
+
+	dialOpts, err := auth.DialOptions(cfg.Auth, credsNodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure gateway credentials: %w", err)
+	}
+	gatewayConn, err := grpc.Dial(discovery.GatewayTarget(cfg), append(dialOpts, discovery.LoadBalancing())...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+	return gatewayConn, nil
+}
+
+func runAuthTest(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Connect to gateway service with the node credentials
+	gatewayConn, err := dialGateway(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer gatewayConn.Close()
+
+	gatewayClient := pb.NewGatewayClient(gatewayConn)
+
+	log.Printf("Registering as node %s for data ID %s (auth mode: %s, credentials for: %s)",
+		nodeID, dataID, cfg.Auth.Mode, credsNodeID)
+	resp, err := gatewayClient.RegisterNode(context.Background(), &pb.RegisterNodeRequest{
+		NodeId: nodeID,
+		DataId: dataID,
+	})
+	if err != nil {
+		st := status.Convert(err)
+		log.Printf("Registration rejected: code=%s message=%s", st.Code(), st.Message())
+		return nil
+	}
+
+	log.Printf("Registration accepted: %s", resp.Message)
+	return nil
+}
+
+func runUnregister(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	gatewayConn, err := dialGateway(cfg)
+	if err != nil {
+		return err
+	}
+	defer gatewayConn.Close()
+
+	gatewayClient := pb.NewGatewayClient(gatewayConn)
+
+	log.Printf("Unregistering node %s from data ID %s (auth mode: %s, credentials for: %s)",
+		nodeID, dataID, cfg.Auth.Mode, credsNodeID)
+	resp, err := gatewayClient.UnregisterNode(context.Background(), &pb.UnregisterNodeRequest{
+		NodeId: nodeID,
+		DataId: dataID,
+	})
+	if err != nil {
+		st := status.Convert(err)
+		log.Printf("Unregistration rejected: code=%s message=%s", st.Code(), st.Message())
+		return nil
+	}
+
+	log.Printf("Unregistration accepted: %s", resp.Message)
+	return nil
+}
+
+func runNodeKey(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Auth.TokenSecret == "" {
+		return fmt.Errorf("auth.token_secret is not set")
+	}
+
+	fmt.Println(auth.NodeKey([]byte(cfg.Auth.TokenSecret), nodeID))
+	return nil
+}
@@ -2,21 +2,27 @@ package main
 
 import (
 	"context"
-	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"event-catcher-gateway/auth"
+	"event-catcher-gateway/client"
 	"event-catcher-gateway/config"
-	pb "event-catcher-gateway/proto"
+	"event-catcher-gateway/discovery"
 
 	"github.com/spf13/cobra"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
 	configPath string
 	dataID     string
+	clientKey  string
+	offset     int64
+	consumer   string
+	group      string
 	rootCmd    = &cobra.Command{
 		Use:   "client",
 		Short: "Event Catcher Client",
@@ -28,6 +34,10 @@ var (
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to config file")
 	rootCmd.PersistentFlags().StringVarP(&dataID, "data-id", "d", "test-data", "data ID to stream")
+	rootCmd.PersistentFlags().StringVarP(&clientKey, "client-key", "k", "", "key for consistent hashing node selection")
+	rootCmd.PersistentFlags().Int64VarP(&offset, "offset", "o", 0, "offset of the first event to stream, unless a checkpoint is stored")
+	rootCmd.PersistentFlags().StringVar(&consumer, "consumer", "", "name under which checkpoints are stored (overrides client.consumer)")
+	rootCmd.PersistentFlags().StringVarP(&group, "group", "g", "", "consumer group whose offsets are committed to the nodes")
 }
 
 func main() {
@@ -45,62 +55,55 @@ func runClient(cmd *cobra.Command, args []string) error {
 	}
 
 	// Connect to gateway service
-	gatewayAddr := cfg.GetGatewayAddr()
-	gatewayConn, err := grpc.Dial(gatewayAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	dialOpts, err := auth.DialOptions(cfg.Auth, "")
 	if err != nil {
-		log.Fatalf("Failed to connect to gateway: %v", err)
+		log.Fatalf("Failed to configure gateway credentials: %v", err)
 	}
-	defer gatewayConn.Close()
-
-	// Create gateway client
-	gatewayClient := pb.NewGatewayClient(gatewayConn)
-
-	// Get node information from gateway
-	ctx := context.Background()
-	nodeResp, err := gatewayClient.GetNodeForData(ctx, &pb.GetNodeRequest{
-		DataId: dataID,
-	})
+	// Checkpoint the position so a restarted client picks up where it left off
+	checkpoints, err := client.NewCheckpointStore(cfg)
 	if err != nil {
-		log.Fatalf("Failed to get node information: %v", err)
+		log.Fatalf("Failed to create checkpoint store: %v", err)
 	}
-
-	log.Printf("Found node %s at %s", nodeResp.NodeId, nodeResp.NodeAddress)
-
-	// Connect to node service
-	nodeConn, err := grpc.Dial(nodeResp.NodeAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	checkpointInterval, err := time.ParseDuration(cfg.Client.Checkpoint.Interval)
 	if err != nil {
-		log.Fatalf("Failed to connect to node: %v", err)
+		log.Fatalf("Invalid checkpoint interval: %v", err)
+	}
+	if consumer == "" {
+		consumer = cfg.Client.Consumer
+	}
+	// Consumer groups commit their offsets to the nodes instead
+	if group != "" {
+		checkpoints = nil
 	}
-	defer nodeConn.Close()
-
-	// Create node client
-	nodeClient := pb.NewNodeClient(nodeConn)
 
-	// Start streaming data
-	stream, err := nodeClient.StreamData(ctx, &pb.StreamRequest{
-		DataId: dataID,
-		Offset: 0,
+	c, err := client.New(discovery.GatewayTarget(cfg), client.Options{
+		ClientKey:          clientKey,
+		GatewayDialOptions: dialOpts,
+		Checkpoints:        checkpoints,
+		Consumer:           consumer,
+		CheckpointEvery:    cfg.Client.Checkpoint.Every,
+		CheckpointInterval: checkpointInterval,
+		ConsumerGroup:      group,
 	})
 	if err != nil {
-		log.Fatalf("Failed to start streaming: %v", err)
+		log.Fatalf("Failed to create client: %v", err)
 	}
-
-	// Receive data chunks
-	for {
-		chunk, err := stream.Recv()
-		if err == io.EOF {
-			log.Println("Stream ended")
-			break
-		}
-		if err != nil {
-			log.Printf("Error receiving chunk: %v", err)
-			break
-		}
-
-		log.Printf("Received chunk: offset=%d, timestamp=%d, data=%s",
-			chunk.Offset,
-			chunk.Timestamp,
-			string(chunk.Data))
+	defer c.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Receive data chunks, reconnecting and resuming after failures
+	err = c.Stream(ctx, dataID, offset, func(event client.Event) error {
+		log.Printf("Received chunk: node=%s, offset=%d, timestamp=%d, data=%s",
+			event.NodeID,
+			event.Offset,
+			event.Timestamp.Unix(),
+			string(event.Data))
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Stream ended: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"event-catcher-gateway/auth"
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/discovery"
+	pb "event-catcher-gateway/proto"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	configPath string
+	dataID     string
+	producerID string
+	rootCmd    = &cobra.Command{
+		Use:   "producer",
+		Short: "Event Catcher Producer",
+		Long:  `A producer publishing lines read from standard input as events to the node serving a data ID.`,
+		RunE:  runProducer,
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to config file")
+	rootCmd.PersistentFlags().StringVarP(&dataID, "data-id", "d", "test-data", "data ID to publish to")
+	rootCmd.PersistentFlags().StringVarP(&producerID, "producer-id", "p", "", "producer ID used to deduplicate retried events")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+func runProducer(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Connect to gateway service
+	dialOpts, err := auth.DialOptions(cfg.Auth, "")
+	if err != nil {
+		log.Fatalf("Failed to configure gateway credentials: %v", err)
+	}
+	gatewayConn, err := grpc.Dial(discovery.GatewayTarget(cfg), append(dialOpts, discovery.LoadBalancing())...)
+	if err != nil {
+		log.Fatalf("Failed to connect to gateway: %v", err)
+	}
+	defer gatewayConn.Close()
+
+	// Find the node serving the data ID
+	ctx := context.Background()
+	nodeResp, err := pb.NewGatewayClient(gatewayConn).GetNodeForData(ctx, &pb.GetNodeRequest{DataId: dataID})
+	if err != nil {
+		log.Fatalf("Failed to get node information: %v", err)
+	}
+	log.Printf("Publishing to node %s at %s", nodeResp.NodeId, nodeResp.NodeAddress)
+
+	nodeConn, err := grpc.Dial(nodeResp.NodeAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to node: %v", err)
+	}
+	defer nodeConn.Close()
+
+	stream, err := pb.NewNodeClient(nodeConn).Publish(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start publishing: %v", err)
+	}
+
+	// Publish every line of standard input as one event, keyed by its line
+	// number so a rerun of the same input with the same producer ID is
+	// deduplicated
+	scanner := bufio.NewScanner(os.Stdin)
+	for line := 1; scanner.Scan(); line++ {
+		if err := stream.Send(&pb.PublishRequest{
+			DataId:         dataID,
+			Data:           []byte(scanner.Text()),
+			ProducerId:     producerID,
+			IdempotencyKey: fmt.Sprintf("%d", line),
+		}); err != nil {
+			log.Fatalf("Failed to publish event: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("Failed to publish events: %v", err)
+	}
+
+	log.Printf("Published %d events (%d duplicates)", len(resp.Offsets), resp.Duplicates)
+	if len(resp.Offsets) > 0 {
+		log.Printf("Assigned offsets %d to %d", resp.Offsets[0], resp.Offsets[len(resp.Offsets)-1])
+	}
+	return nil
+}
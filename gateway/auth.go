@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/auth"
+	"event-catcher-gateway/config"
+)
+
+// Authenticator verifies that the caller of an RPC is the node it claims to be
+type Authenticator interface {
+	Authenticate(ctx context.Context, nodeID string) error
+}
+
+// NewAuthenticator creates the authenticator for the configured auth mode
+func NewAuthenticator(cfg config.AuthConfig) (Authenticator, error) {
+	switch cfg.Mode {
+	case "", auth.ModeNone:
+		return noAuthenticator{}, nil
+	case auth.ModeMTLS:
+		return mtlsAuthenticator{}, nil
+	case auth.ModeToken:
+		if cfg.TokenSecret == "" {
+			return nil, fmt.Errorf("auth mode %s requires auth.token_secret", auth.ModeToken)
+		}
+		maxSkew, err := time.ParseDuration(cfg.TokenMaxSkew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth.token_max_skew: %w", err)
+		}
+		return tokenAuthenticator{secret: []byte(cfg.TokenSecret), maxSkew: maxSkew}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode: %s", cfg.Mode)
+	}
+}
+
+// noAuthenticator trusts the node ID in the request
+type noAuthenticator struct{}
+
+func (noAuthenticator) Authenticate(ctx context.Context, nodeID string) error {
+	return nil
+}
+
+// mtlsAuthenticator matches the node ID against the common name or DNS
+// subject alternative names of the verified client certificate
+type mtlsAuthenticator struct{}
+
+func (mtlsAuthenticator) Authenticate(ctx context.Context, nodeID string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return status.Error(codes.Unauthenticated, "a verified client certificate is required")
+	}
+
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	if !certificateMatches(cert, nodeID) {
+		return status.Errorf(codes.PermissionDenied, "client certificate %q does not match node %s", cert.Subject.CommonName, nodeID)
+	}
+	return nil
+}
+
+func certificateMatches(cert *x509.Certificate, nodeID string) bool {
+	return cert.Subject.CommonName == nodeID || slices.Contains(cert.DNSNames, nodeID)
+}
+
+// tokenAuthenticator checks the HMAC token carried in the gRPC metadata
+type tokenAuthenticator struct {
+	secret  []byte
+	maxSkew time.Duration
+}
+
+func (a tokenAuthenticator) Authenticate(ctx context.Context, nodeID string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(auth.TokenMetadataKey)
+	if len(tokens) == 0 {
+		return status.Error(codes.Unauthenticated, "missing node token")
+	}
+
+	tokenNodeID, err := auth.VerifyToken(a.secret, tokens[0], a.maxSkew, time.Now())
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	if tokenNodeID != nodeID {
+		return status.Errorf(codes.PermissionDenied, "token was issued for node %s, not %s", tokenNodeID, nodeID)
+	}
+	return nil
+}
+
+// authenticateNodeOrAdmin accepts the node itself or the operator identity
+// configured in auth.admin_id. The node's error is returned when the caller
+// is neither.
+func (s *Service) authenticateNodeOrAdmin(ctx context.Context, nodeID string) error {
+	err := s.authenticator.Authenticate(ctx, nodeID)
+	if err == nil || s.adminID == "" {
+		return err
+	}
+	if s.authenticator.Authenticate(ctx, s.adminID) == nil {
+		return nil
+	}
+	return err
+}
+
+// checkNotAdmin keeps nodes from registering under the operator identity,
+// which would let the holder of a node credential unregister every node
+func (s *Service) checkNotAdmin(nodeID string) error {
+	if s.adminID != "" && nodeID == s.adminID {
+		return status.Errorf(codes.PermissionDenied, "node ID %s is reserved for the operator identity", nodeID)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/auth"
+	pb "event-catcher-gateway/proto"
+)
+
+func TestCertificateMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		nodeID string
+		want   bool
+	}{
+		{
+			name:   "common name",
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "node1"}},
+			nodeID: "node1",
+			want:   true,
+		},
+		{
+			name:   "DNS SAN",
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "nodes"}, DNSNames: []string{"node0", "node1"}},
+			nodeID: "node1",
+			want:   true,
+		},
+		{
+			name:   "other node",
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "node2"}, DNSNames: []string{"node2.local"}},
+			nodeID: "node1",
+		},
+		{
+			name:   "prefix only",
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "node10"}},
+			nodeID: "node1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := certificateMatches(tt.cert, tt.nodeID); got != tt.want {
+				t.Errorf("certificateMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	withCert := func(cert *x509.Certificate) context.Context {
+		state := tls.ConnectionState{}
+		if cert != nil {
+			state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{
+			name:     "matching certificate",
+			ctx:      withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "certificate of another node",
+			ctx:      withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "node2"}}),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "no verified certificate",
+			ctx:      withCert(nil),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "no peer",
+			ctx:      context.Background(),
+			wantCode: codes.Unauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mtlsAuthenticator{}.Authenticate(tt.ctx, "node1")
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Authenticate() = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	secret := []byte("gateway-secret")
+	a := tokenAuthenticator{secret: secret, maxSkew: time.Minute}
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		token    string
+		claimed  string
+		wantCode codes.Code
+	}{
+		{
+			name:     "own token",
+			token:    auth.SignToken([]byte(auth.NodeKey(secret, "node1")), "node1", now),
+			claimed:  "node1",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "token issued for another node",
+			token:    auth.SignToken([]byte(auth.NodeKey(secret, "node2")), "node2", now),
+			claimed:  "node1",
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "token for another node signed with own key",
+			token:    auth.SignToken([]byte(auth.NodeKey(secret, "node2")), "node1", now),
+			claimed:  "node1",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "missing token",
+			claimed:  "node1",
+			wantCode: codes.Unauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(auth.TokenMetadataKey, tt.token))
+			}
+			err := a.Authenticate(ctx, tt.claimed)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Authenticate() = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestUnregisterNodeAsOperator(t *testing.T) {
+	secret := []byte("gateway-secret")
+	now := time.Now()
+	token := func(nodeID string) string {
+		return auth.SignToken([]byte(auth.NodeKey(secret, nodeID)), nodeID, now)
+	}
+
+	tests := []struct {
+		name     string
+		adminID  string
+		token    string
+		wantCode codes.Code
+	}{
+		{name: "node itself", adminID: "admin", token: token("node1"), wantCode: codes.OK},
+		{name: "operator", adminID: "admin", token: token("admin"), wantCode: codes.OK},
+		{name: "other node", adminID: "admin", token: token("node2"), wantCode: codes.PermissionDenied},
+		{name: "operator disabled", adminID: "", token: token("admin"), wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(NewMemoryRegistry(), []string{"node1", "node2"})
+			if _, err := s.RegisterNode(context.Background(), &pb.RegisterNodeRequest{NodeId: "node1", DataId: "data"}); err != nil {
+				t.Fatalf("RegisterNode() = %v", err)
+			}
+			s.authenticator = tokenAuthenticator{secret: secret, maxSkew: time.Minute}
+			s.adminID = tt.adminID
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(auth.TokenMetadataKey, tt.token))
+			_, err := s.UnregisterNode(ctx, &pb.UnregisterNodeRequest{NodeId: "node1", DataId: "data"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnregisterNode() = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNodesCannotRegisterAsOperator(t *testing.T) {
+	s := newTestService(NewMemoryRegistry(), []string{"admin"})
+	s.adminID = "admin"
+
+	_, err := s.RegisterNode(context.Background(), &pb.RegisterNodeRequest{NodeId: "admin", DataId: "data"})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("RegisterNode() = %v, want %v", err, codes.PermissionDenied)
+	}
+}
@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/config"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// errLeaseNotFound is returned when renewing the lease of a node that has
+// none, usually because it expired
+var errLeaseNotFound = errors.New("lease not found")
+
+// Leases tracks which nodes are alive. A node holds a lease while it keeps
+// renewing it with heartbeats; the registrations of nodes without a lease
+// are removed.
+type Leases interface {
+	// TTL returns how long a lease lasts without renewal
+	TTL() time.Duration
+
+	// Grant gives a node a lease, keeping its current one if it has one.
+	// It reports whether the lease is new.
+	Grant(nodeID string) (bool, error)
+
+	// Renew extends the lease of a node, or returns errLeaseNotFound
+	Renew(nodeID string) error
+
+	// Holds reports whether a node currently holds a lease
+	Holds(nodeID string) (bool, error)
+
+	// Live returns the nodes currently holding a lease
+	Live() (map[string]bool, error)
+}
+
+// NewLeases creates the leases configured in cfg, backed by Consul sessions
+// with the Consul registry and kept in memory otherwise. It returns nil when
+// leases are disabled.
+func NewLeases(cfg *config.Config, registry Registry) (Leases, error) {
+	if !cfg.Gateway.Leases.Enabled {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(cfg.Gateway.Leases.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway.leases.ttl: %w", err)
+	}
+
+	if consulRegistry, ok := consulBackend(registry); ok {
+		// Consul only accepts session TTLs between 10s and 24h
+		if ttl < 10*time.Second || ttl > 24*time.Hour {
+			return nil, fmt.Errorf("gateway.leases.ttl must be between 10s and 24h, got %v", ttl)
+		}
+		return &ConsulLeases{
+			client: consulRegistry.client,
+			prefix: cfg.Gateway.Leases.KVPrefix,
+			ttl:    ttl,
+		}, nil
+	}
+
+	if ttl <= 0 {
+		return nil, fmt.Errorf("gateway.leases.ttl must be positive")
+	}
+	return NewMemoryLeases(ttl), nil
+}
+
+// ConsulLeases backs each lease with a Consul session holding the key
+// <prefix><node ID>. When a node stops renewing, Consul invalidates the
+// session and deletes the key, so every gateway sharing the cluster sees
+// the lease go away.
+type ConsulLeases struct {
+	client *api.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// TTL implements Leases
+func (l *ConsulLeases) TTL() time.Duration {
+	return l.ttl
+}
+
+// Grant implements Leases
+func (l *ConsulLeases) Grant(nodeID string) (bool, error) {
+	err := l.Renew(nodeID)
+	if !errors.Is(err, errLeaseNotFound) {
+		return false, err
+	}
+
+	sessionID, _, err := l.client.Session().Create(&api.SessionEntry{
+		Name:     "streaming-node-" + nodeID,
+		TTL:      l.ttl.String(),
+		Behavior: api.SessionBehaviorDelete,
+		// Let a restarted node take its lease back right away
+		LockDelay: time.Millisecond,
+	}, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create Consul session: %w", err)
+	}
+
+	acquired, _, err := l.client.KV().Acquire(&api.KVPair{
+		Key:     l.prefix + nodeID,
+		Value:   []byte(nodeID),
+		Session: sessionID,
+	}, nil)
+	if err != nil || !acquired {
+		l.client.Session().Destroy(sessionID, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire lease of node %s: %w", nodeID, err)
+		}
+		// Another gateway granted the lease concurrently
+		return false, l.Renew(nodeID)
+	}
+	return true, nil
+}
+
+// Renew implements Leases
+func (l *ConsulLeases) Renew(nodeID string) error {
+	pair, _, err := l.client.KV().Get(l.prefix+nodeID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read lease of node %s: %w", nodeID, err)
+	}
+	if pair == nil || pair.Session == "" {
+		return errLeaseNotFound
+	}
+
+	entry, _, err := l.client.Session().Renew(pair.Session, nil)
+	if err != nil {
+		return fmt.Errorf("failed to renew lease of node %s: %w", nodeID, err)
+	}
+	if entry == nil {
+		return errLeaseNotFound
+	}
+	return nil
+}
+
+// Holds implements Leases
+func (l *ConsulLeases) Holds(nodeID string) (bool, error) {
+	pair, _, err := l.client.KV().Get(l.prefix+nodeID, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to read lease of node %s: %w", nodeID, err)
+	}
+	return pair != nil && pair.Session != "", nil
+}
+
+// Live implements Leases
+func (l *ConsulLeases) Live() (map[string]bool, error) {
+	pairs, _, err := l.client.KV().List(l.prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list leases: %w", err)
+	}
+
+	live := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		if pair.Session != "" {
+			live[strings.TrimPrefix(pair.Key, l.prefix)] = true
+		}
+	}
+	return live, nil
+}
+
+// MemoryLeases keeps lease deadlines in process memory, for use with the
+// memory registry
+type MemoryLeases struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+}
+
+// NewMemoryLeases creates leases lasting ttl without renewal
+func NewMemoryLeases(ttl time.Duration) *MemoryLeases {
+	return &MemoryLeases{ttl: ttl, deadlines: make(map[string]time.Time)}
+}
+
+// TTL implements Leases
+func (l *MemoryLeases) TTL() time.Duration {
+	return l.ttl
+}
+
+// Grant implements Leases
+func (l *MemoryLeases) Grant(nodeID string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	deadline, ok := l.deadlines[nodeID]
+	l.deadlines[nodeID] = now.Add(l.ttl)
+	return !ok || now.After(deadline), nil
+}
+
+// Renew implements Leases
+func (l *MemoryLeases) Renew(nodeID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deadline, ok := l.deadlines[nodeID]
+	if !ok || time.Now().After(deadline) {
+		delete(l.deadlines, nodeID)
+		return errLeaseNotFound
+	}
+	l.deadlines[nodeID] = time.Now().Add(l.ttl)
+	return nil
+}
+
+// Holds implements Leases
+func (l *MemoryLeases) Holds(nodeID string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deadline, ok := l.deadlines[nodeID]
+	return ok && !time.Now().After(deadline), nil
+}
+
+// Live implements Leases
+func (l *MemoryLeases) Live() (map[string]bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	live := make(map[string]bool, len(l.deadlines))
+	for nodeID, deadline := range l.deadlines {
+		if now.After(deadline) {
+			delete(l.deadlines, nodeID)
+			continue
+		}
+		live[nodeID] = true
+	}
+	return live, nil
+}
+
+// reapExpired removes the registrations of nodes without a lease every half
+// TTL until the context is cancelled
+func (s *Service) reapExpired(ctx context.Context) {
+	ticker := time.NewTicker(s.leases.TTL() / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.expireRegistrations(); err != nil {
+				log.Printf("Failed to expire registrations: %v", err)
+			}
+		}
+	}
+}
+
+// expireRegistrations removes the nodes without a lease from all mappings.
+// The leases read up front only pick the candidates. Each removal checks
+// the lease again inside the mapping update, and a node granted a new lease
+// rewrites its mappings, so a removal racing with a registration either
+// sees the new lease or conflicts with the registration's write and is
+// retried.
+func (s *Service) expireRegistrations() error {
+	mappings, err := s.registry.ListMappings()
+	if err != nil {
+		return err
+	}
+	live, err := s.leases.Live()
+	if err != nil {
+		return err
+	}
+
+	for dataID, mapping := range mappings {
+		for _, nodeID := range mapping.NodeIDs() {
+			if live[nodeID] {
+				continue
+			}
+
+			removed := false
+			var leaseErr error
+			if _, err := s.registry.UpdateMapping(dataID, func(mapping *NodeMapping) bool {
+				removed = false
+				held, err := s.leases.Holds(nodeID)
+				if leaseErr = err; err != nil || held {
+					return false
+				}
+				removed = mapping.Remove(nodeID)
+				return removed
+			}); err != nil {
+				return err
+			}
+			if leaseErr != nil {
+				return leaseErr
+			}
+			if removed {
+				log.Printf("Registration of node %s for data ID %s expired", nodeID, dataID)
+			}
+		}
+	}
+	return nil
+}
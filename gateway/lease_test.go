@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	pb "event-catcher-gateway/proto"
+)
+
+// racingKV runs a hook once, right before the next write it is asked for
+type racingKV struct {
+	*fakeKV
+	hook func()
+}
+
+func (kv *racingKV) runHook() {
+	if hook := kv.hook; hook != nil {
+		kv.hook = nil
+		hook()
+	}
+}
+
+func (kv *racingKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.runHook()
+	return kv.fakeKV.CAS(p, q)
+}
+
+func (kv *racingKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.runHook()
+	return kv.fakeKV.DeleteCAS(p, q)
+}
+
+func TestExpireRegistrationsKeepsNodeRegisteringConcurrently(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodeIDs []string
+	}{
+		{name: "last node of the mapping", nodeIDs: []string{"node1"}},
+		{name: "one of several nodes", nodeIDs: []string{"node1", "node2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := &racingKV{fakeKV: newFakeKV()}
+			s := newTestService(newConsulTestRegistry(kv), tt.nodeIDs)
+			s.leases = NewMemoryLeases(time.Minute)
+			ctx := context.Background()
+
+			for _, nodeID := range tt.nodeIDs {
+				if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: nodeID, DataId: "test-data"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// node1's lease expires, and it registers again after the reaper
+			// decided to remove it but before the removal is written
+			delete(s.leases.(*MemoryLeases).deadlines, "node1")
+			kv.hook = func() {
+				if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: "node1", DataId: "test-data"}); err != nil {
+					t.Error(err)
+				}
+			}
+
+			if err := s.expireRegistrations(); err != nil {
+				t.Fatal(err)
+			}
+			mapping, err := s.registry.GetMapping("test-data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapping == nil {
+				t.Fatal("mapping was deleted")
+			}
+			if _, found := mapping.Get("node1"); !found {
+				t.Errorf("node1 was removed although it registered again with a new lease")
+			}
+		})
+	}
+}
+
+func TestExpireRegistrationsRemovesExpiredNodes(t *testing.T) {
+	for _, backend := range testRegistries {
+		t.Run(backend.name, func(t *testing.T) {
+			s := newTestService(backend.new(), []string{"node1", "node2"})
+			s.leases = NewMemoryLeases(time.Minute)
+			ctx := context.Background()
+
+			for _, nodeID := range []string{"node1", "node2"} {
+				if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: nodeID, DataId: "test-data"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			delete(s.leases.(*MemoryLeases).deadlines, "node1")
+
+			if err := s.expireRegistrations(); err != nil {
+				t.Fatal(err)
+			}
+			mapping, err := s.registry.GetMapping("test-data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, found := mapping.Get("node1"); found {
+				t.Error("expired node1 is still registered")
+			}
+			if _, found := mapping.Get("node2"); !found {
+				t.Error("node2 holding a lease was removed")
+			}
+		})
+	}
+}
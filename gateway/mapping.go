@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Version of the node mapping document written to Consul KV
+const mappingVersion = 1
+
+// Roles a node can have for a data ID
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// NodeMapping is the document stored in Consul KV for each data ID
+type NodeMapping struct {
+	Version int         `json:"version"`
+	Nodes   []NodeEntry `json:"nodes"`
+}
+
+// NodeEntry describes one node registered for a data ID
+type NodeEntry struct {
+	NodeID       string            `json:"node_id"`
+	Address      string            `json:"address,omitempty"`
+	Weight       int               `json:"weight,omitempty"`
+	RegisteredAt time.Time         `json:"registered_at"`
+	Role         string            `json:"role,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// decodeMapping parses a node mapping document. Values written before the
+// JSON format was introduced hold a comma-separated list of node IDs and are
+// still accepted.
+func decodeMapping(value []byte) (*NodeMapping, error) {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		mapping := &NodeMapping{Version: mappingVersion}
+		for _, nodeID := range parseNodeList(string(trimmed)) {
+			if nodeID != "" {
+				mapping.Nodes = append(mapping.Nodes, NodeEntry{NodeID: nodeID})
+			}
+		}
+		return mapping, nil
+	}
+
+	var mapping NodeMapping
+	if err := json.Unmarshal(trimmed, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid node mapping: %w", err)
+	}
+	if mapping.Version > mappingVersion {
+		return nil, fmt.Errorf("unsupported node mapping version %d", mapping.Version)
+	}
+	mapping.Version = mappingVersion
+	return &mapping, nil
+}
+
+// encode serializes the mapping as a JSON document
+func (m *NodeMapping) encode() ([]byte, error) {
+	m.Version = mappingVersion
+	return json.Marshal(m)
+}
+
+// NodeIDs returns the IDs of the registered nodes in registration order
+func (m *NodeMapping) NodeIDs() []string {
+	nodeIDs := make([]string, len(m.Nodes))
+	for i, entry := range m.Nodes {
+		nodeIDs[i] = entry.NodeID
+	}
+	return nodeIDs
+}
+
+// Get returns the entry of a node, if registered
+func (m *NodeMapping) Get(nodeID string) (NodeEntry, bool) {
+	i := m.index(nodeID)
+	if i < 0 {
+		return NodeEntry{}, false
+	}
+	return m.Nodes[i], true
+}
+
+// Put adds or replaces the entry of a node
+func (m *NodeMapping) Put(entry NodeEntry) {
+	if i := m.index(entry.NodeID); i >= 0 {
+		m.Nodes[i] = entry
+		return
+	}
+	m.Nodes = append(m.Nodes, entry)
+}
+
+// Remove deletes the entry of a node and reports whether it was registered
+func (m *NodeMapping) Remove(nodeID string) bool {
+	i := m.index(nodeID)
+	if i < 0 {
+		return false
+	}
+	m.Nodes = slices.Delete(m.Nodes, i, i+1)
+	return true
+}
+
+func (m *NodeMapping) index(nodeID string) int {
+	return slices.IndexFunc(m.Nodes, func(entry NodeEntry) bool { return entry.NodeID == nodeID })
+}
+
+// sameAttributes reports whether two entries carry the same registration details
+func (e NodeEntry) sameAttributes(other NodeEntry) bool {
+	if e.Address != other.Address || e.Weight != other.Weight || e.Role != other.Role || len(e.Labels) != len(other.Labels) {
+		return false
+	}
+	for k, v := range e.Labels {
+		if other.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper function to parse a comma-separated list of node IDs
+func parseNodeList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+
+	// Split by comma and trim whitespace
+	nodes := strings.Split(value, ",")
+	for i, node := range nodes {
+		nodes[i] = strings.TrimSpace(node)
+	}
+	return nodes
+}
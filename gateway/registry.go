@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"event-catcher-gateway/config"
+)
+
+// Supported registry backends
+const (
+	RegistryConsul = "consul"
+	RegistryMemory = "memory"
+)
+
+// NodeInstance is a healthy instance of a streaming node
+type NodeInstance struct {
+	NodeID  string
+	Address string
+	Meta    map[string]string
+}
+
+// Registry stores the data-to-node mappings and knows which nodes are healthy
+type Registry interface {
+	// GetMapping returns the node mapping of a data ID, or nil if none exists
+	GetMapping(dataID string) (*NodeMapping, error)
+
+	// UpdateMapping atomically applies update to the node mapping of a data
+	// ID. update reports whether it changed the mapping; a mapping left
+	// without nodes is deleted. The resulting mapping is returned.
+	UpdateMapping(dataID string, update func(mapping *NodeMapping) bool) (*NodeMapping, error)
+
+	// ListMappings returns all node mappings keyed by data ID
+	ListMappings() (map[string]*NodeMapping, error)
+
+	// HealthyNodes returns the healthy node instances keyed by node ID
+	HealthyNodes() (map[string]NodeInstance, error)
+
+	// Check reports whether the backend is reachable
+	Check() error
+}
+
+// NewRegistry creates the registry backend selected in the configuration
+func NewRegistry(cfg *config.Config) (Registry, error) {
+	switch cfg.Gateway.Registry {
+	case "", RegistryConsul:
+		consulConfig := api.DefaultConfig()
+		consulConfig.Address = cfg.GetConsulAddr()
+
+		client, err := api.NewClient(consulConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Consul client: %v", err)
+		}
+
+		registry := NewConsulRegistry(client, cfg.Consul.KVPrefix)
+		if !cfg.Gateway.Cache.Enabled {
+			return registry, nil
+		}
+
+		maxStaleness, err := time.ParseDuration(cfg.Gateway.Cache.MaxStaleness)
+		if err != nil || maxStaleness <= 0 {
+			return nil, fmt.Errorf("invalid gateway.cache.max_staleness: %q", cfg.Gateway.Cache.MaxStaleness)
+		}
+		return NewCachedRegistry(registry, maxStaleness), nil
+	case RegistryMemory:
+		return NewMemoryRegistry(), nil
+	default:
+		return nil, fmt.Errorf("unknown registry backend: %s", cfg.Gateway.Registry)
+	}
+}
+
+// consulBackend returns the Consul registry behind a registry, if any
+func consulBackend(registry Registry) (*ConsulRegistry, bool) {
+	switch r := registry.(type) {
+	case *ConsulRegistry:
+		return r, true
+	case *CachedRegistry:
+		return r.inner, true
+	default:
+		return nil, false
+	}
+}
+
+// clone returns a deep copy of the mapping
+func (m *NodeMapping) clone() *NodeMapping {
+	copied := &NodeMapping{Version: m.Version, Nodes: make([]NodeEntry, len(m.Nodes))}
+	for i, entry := range m.Nodes {
+		entry.Labels = maps.Clone(entry.Labels)
+		copied.Nodes[i] = entry
+	}
+	return copied
+}
@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/consulwatch"
+)
+
+// CachedRegistry serves lookups from an in-memory view of a ConsulRegistry
+// that is kept up to date with blocking queries. When a view has not been
+// confirmed by Consul within the staleness limit, for example because the
+// agent is unreachable, lookups fall back to reading Consul directly.
+type CachedRegistry struct {
+	inner        *ConsulRegistry
+	maxStaleness time.Duration
+
+	mu             sync.RWMutex
+	mappings       map[string]*NodeMapping
+	mappingsSynced time.Time
+	healthy        map[string]NodeInstance
+	healthySynced  time.Time
+}
+
+// NewCachedRegistry creates a cache in front of a Consul registry. The cache
+// stays cold until Run is started.
+func NewCachedRegistry(inner *ConsulRegistry, maxStaleness time.Duration) *CachedRegistry {
+	return &CachedRegistry{
+		inner:        inner,
+		maxStaleness: maxStaleness,
+	}
+}
+
+// Run keeps the cache in sync with Consul until the context is cancelled.
+// The wait time of the blocking queries is bounded by the staleness limit so
+// every successful round trip confirms the cached view.
+func (r *CachedRegistry) Run(ctx context.Context) {
+	waitTime := min(r.maxStaleness, consulwatch.MaxWaitTime)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		consulwatch.Watch(ctx, waitTime, r.inner.listMappings,
+			func(mappings map[string]*NodeMapping, changed bool) {
+				r.mu.Lock()
+				r.mappings, r.mappingsSynced = mappings, time.Now()
+				r.mu.Unlock()
+			},
+			func(err error) {
+				log.Printf("Failed to watch node mappings in Consul: %v", err)
+			})
+	}()
+	go func() {
+		defer wg.Done()
+		consulwatch.Watch(ctx, waitTime, r.inner.healthyNodes,
+			func(healthy map[string]NodeInstance, changed bool) {
+				r.mu.Lock()
+				r.healthy, r.healthySynced = healthy, time.Now()
+				r.mu.Unlock()
+			},
+			func(err error) {
+				log.Printf("Failed to watch node health in Consul: %v", err)
+			})
+	}()
+	wg.Wait()
+}
+
+// fresh reports whether a view synced at the given time may still be served.
+// Consul adds up to 1/16th of the wait time as jitter to blocking queries.
+func (r *CachedRegistry) fresh(synced time.Time) bool {
+	return !synced.IsZero() && time.Since(synced) <= r.maxStaleness+r.maxStaleness/16
+}
+
+// GetMapping implements Registry
+func (r *CachedRegistry) GetMapping(dataID string) (*NodeMapping, error) {
+	r.mu.RLock()
+	if r.fresh(r.mappingsSynced) {
+		defer r.mu.RUnlock()
+		if mapping, ok := r.mappings[dataID]; ok {
+			return mapping.clone(), nil
+		}
+		return nil, nil
+	}
+	r.mu.RUnlock()
+
+	return r.inner.GetMapping(dataID)
+}
+
+// UpdateMapping implements Registry. Updates always go to Consul and are
+// written through to the cache so they are visible to the next lookup.
+func (r *CachedRegistry) UpdateMapping(dataID string, update func(mapping *NodeMapping) bool) (*NodeMapping, error) {
+	mapping, err := r.inner.UpdateMapping(dataID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.mappings != nil {
+		if len(mapping.Nodes) == 0 {
+			delete(r.mappings, dataID)
+		} else {
+			r.mappings[dataID] = mapping.clone()
+		}
+	}
+	r.mu.Unlock()
+
+	return mapping, nil
+}
+
+// ListMappings implements Registry
+func (r *CachedRegistry) ListMappings() (map[string]*NodeMapping, error) {
+	r.mu.RLock()
+	if r.fresh(r.mappingsSynced) {
+		defer r.mu.RUnlock()
+		mappings := make(map[string]*NodeMapping, len(r.mappings))
+		for dataID, mapping := range r.mappings {
+			mappings[dataID] = mapping.clone()
+		}
+		return mappings, nil
+	}
+	r.mu.RUnlock()
+
+	return r.inner.ListMappings()
+}
+
+// HealthyNodes implements Registry
+func (r *CachedRegistry) HealthyNodes() (map[string]NodeInstance, error) {
+	r.mu.RLock()
+	if r.fresh(r.healthySynced) {
+		defer r.mu.RUnlock()
+		return maps.Clone(r.healthy), nil
+	}
+	r.mu.RUnlock()
+
+	return r.inner.HealthyNodes()
+}
+
+// Check implements Registry. Even though lookups can be served from the
+// cache for a while, registrations and whitelist updates need Consul.
+func (r *CachedRegistry) Check() error {
+	return r.inner.Check()
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+const testMaxStaleness = time.Second
+
+// putNode registers a node for a data ID in a registry
+func putNode(t *testing.T, registry Registry, dataID, nodeID string) {
+	t.Helper()
+	_, err := registry.UpdateMapping(dataID, func(mapping *NodeMapping) bool {
+		mapping.Put(NodeEntry{NodeID: nodeID})
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mappingNodes returns the node IDs of a mapping
+func mappingNodes(mapping *NodeMapping) []string {
+	if mapping == nil {
+		return nil
+	}
+	var nodeIDs []string
+	for _, entry := range mapping.Nodes {
+		nodeIDs = append(nodeIDs, entry.NodeID)
+	}
+	return nodeIDs
+}
+
+func TestCachedRegistryReadsInnerWhenStale(t *testing.T) {
+	tests := []struct {
+		name     string
+		synced   time.Duration
+		wantNode string
+	}{
+		{name: "fresh view", synced: 0, wantNode: "cached-node"},
+		{name: "stale view", synced: 2 * testMaxStaleness, wantNode: "consul-node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := newConsulTestRegistry(newFakeKV())
+			putNode(t, inner, "test-data", "consul-node")
+
+			// The cached view disagrees with Consul, so reads show where
+			// they were served from
+			r := NewCachedRegistry(inner, testMaxStaleness)
+			r.mappings = map[string]*NodeMapping{"test-data": {}}
+			r.mappings["test-data"].Put(NodeEntry{NodeID: "cached-node"})
+			r.mappingsSynced = time.Now().Add(-tt.synced)
+
+			mapping, err := r.GetMapping("test-data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mappingNodes(mapping); len(got) != 1 || got[0] != tt.wantNode {
+				t.Errorf("GetMapping() nodes = %v, want [%s]", got, tt.wantNode)
+			}
+
+			mappings, err := r.ListMappings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mappingNodes(mappings["test-data"]); len(got) != 1 || got[0] != tt.wantNode {
+				t.Errorf("ListMappings() nodes = %v, want [%s]", got, tt.wantNode)
+			}
+		})
+	}
+}
+
+func TestCachedRegistryWritesThrough(t *testing.T) {
+	r := NewCachedRegistry(newConsulTestRegistry(newFakeKV()), testMaxStaleness)
+	// A fresh view the watch would only update after the write
+	r.mappings = make(map[string]*NodeMapping)
+	r.mappingsSynced = time.Now()
+
+	putNode(t, r, "test-data", "node1")
+	mapping, err := r.GetMapping("test-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mappingNodes(mapping); len(got) != 1 || got[0] != "node1" {
+		t.Errorf("GetMapping() after registering = %v, want [node1]", got)
+	}
+
+	if _, err := r.UpdateMapping("test-data", func(mapping *NodeMapping) bool {
+		return mapping.Remove("node1")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if mapping, err := r.GetMapping("test-data"); err != nil || mapping != nil {
+		t.Errorf("GetMapping() after unregistering = %v, %v, want no mapping", mappingNodes(mapping), err)
+	}
+}
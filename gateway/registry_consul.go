@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Name of the Consul service streaming nodes register as
+const nodeServiceName = "streaming-node"
+
+// Retry limits for check-and-set updates of the node mapping
+const (
+	maxCASAttempts  = 32
+	casRetryBackoff = 5 * time.Millisecond
+	maxCASBackoff   = 500 * time.Millisecond
+)
+
+// kvStore is the subset of the Consul KV API used by the gateway.
+// *api.KV satisfies it.
+type kvStore interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+// ConsulRegistry keeps the node mappings in Consul KV and reads node health
+// from the Consul service catalog
+type ConsulRegistry struct {
+	client   *api.Client
+	kv       kvStore
+	kvPrefix string
+}
+
+// NewConsulRegistry creates a registry backed by Consul
+func NewConsulRegistry(client *api.Client, kvPrefix string) *ConsulRegistry {
+	return &ConsulRegistry{
+		client:   client,
+		kv:       client.KV(),
+		kvPrefix: kvPrefix,
+	}
+}
+
+// GetMapping implements Registry
+func (r *ConsulRegistry) GetMapping(dataID string) (*NodeMapping, error) {
+	kvPair, _, err := r.kv.Get(r.kvPrefix+dataID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Consul KV store: %w", err)
+	}
+	if kvPair == nil {
+		return nil, nil
+	}
+	return decodeMapping(kvPair.Value)
+}
+
+// UpdateMapping implements Registry. The update is retried against the
+// latest mapping whenever another writer modified the key in between, so
+// concurrent registrations never lose entries.
+func (r *ConsulRegistry) UpdateMapping(dataID string, update func(mapping *NodeMapping) bool) (*NodeMapping, error) {
+	key := r.kvPrefix + dataID
+
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		kvPair, _, err := r.kv.Get(key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query Consul KV store: %w", err)
+		}
+
+		// A ModifyIndex of 0 makes the CAS succeed only if the key doesn't exist yet
+		mapping := &NodeMapping{Version: mappingVersion}
+		var modifyIndex uint64
+		if kvPair != nil {
+			if mapping, err = decodeMapping(kvPair.Value); err != nil {
+				return nil, err
+			}
+			modifyIndex = kvPair.ModifyIndex
+		}
+
+		if !update(mapping) {
+			return mapping, nil
+		}
+
+		var ok bool
+		if len(mapping.Nodes) == 0 {
+			if kvPair == nil {
+				return mapping, nil
+			}
+			ok, _, err = r.kv.DeleteCAS(&api.KVPair{Key: key, ModifyIndex: modifyIndex}, nil)
+		} else {
+			value, encodeErr := mapping.encode()
+			if encodeErr != nil {
+				return nil, fmt.Errorf("failed to encode node mapping: %w", encodeErr)
+			}
+			ok, _, err = r.kv.CAS(&api.KVPair{
+				Key:         key,
+				Value:       value,
+				ModifyIndex: modifyIndex,
+			}, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store node mapping: %w", err)
+		}
+		if ok {
+			return mapping, nil
+		}
+
+		// Lost the race with another writer, back off with jitter and retry
+		time.Sleep(casBackoff(attempt))
+	}
+
+	return nil, fmt.Errorf("failed to store node mapping for data ID %s: too much contention", dataID)
+}
+
+// ListMappings implements Registry
+func (r *ConsulRegistry) ListMappings() (map[string]*NodeMapping, error) {
+	mappings, _, err := r.listMappings(nil)
+	return mappings, err
+}
+
+// listMappings reads all node mappings, optionally as a blocking query
+func (r *ConsulRegistry) listMappings(opts *api.QueryOptions) (map[string]*NodeMapping, *api.QueryMeta, error) {
+	kvPairs, meta, err := r.kv.List(r.kvPrefix, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query Consul KV store: %w", err)
+	}
+
+	mappings := make(map[string]*NodeMapping, len(kvPairs))
+	for _, kvPair := range kvPairs {
+		dataID := strings.TrimPrefix(kvPair.Key, r.kvPrefix)
+		if dataID == "" {
+			continue
+		}
+		mapping, err := decodeMapping(kvPair.Value)
+		if err != nil {
+			log.Printf("Skipping invalid node mapping for data ID %s: %v", dataID, err)
+			continue
+		}
+		mappings[dataID] = mapping
+	}
+	return mappings, meta, nil
+}
+
+// HealthyNodes implements Registry
+func (r *ConsulRegistry) HealthyNodes() (map[string]NodeInstance, error) {
+	healthy, _, err := r.healthyNodes(nil)
+	return healthy, err
+}
+
+// Check implements Registry by asking Consul for its leader, which fails
+// when the agent is unreachable or the cluster has lost quorum
+func (r *ConsulRegistry) Check() error {
+	leader, err := r.client.Status().Leader()
+	if err != nil {
+		return fmt.Errorf("failed to reach Consul: %w", err)
+	}
+	if leader == "" {
+		return fmt.Errorf("no Consul leader elected")
+	}
+	return nil
+}
+
+// healthyNodes reads the healthy node instances, optionally as a blocking query
+func (r *ConsulRegistry) healthyNodes(opts *api.QueryOptions) (map[string]NodeInstance, *api.QueryMeta, error) {
+	services, meta, err := r.client.Health().Service(nodeServiceName, "", true, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query Consul service catalog: %w", err)
+	}
+
+	healthy := make(map[string]NodeInstance, len(services))
+	for _, service := range services {
+		healthy[service.Service.ID] = NodeInstance{
+			NodeID:  service.Service.ID,
+			Address: net.JoinHostPort(service.Service.Address, strconv.Itoa(service.Service.Port)),
+			Meta:    service.Service.Meta,
+		}
+	}
+	return healthy, meta, nil
+}
+
+// casBackoff returns a jittered exponential backoff for a CAS retry attempt
+func casBackoff(attempt int) time.Duration {
+	backoff := casRetryBackoff << min(attempt, 10)
+	if backoff > maxCASBackoff {
+		backoff = maxCASBackoff
+	}
+	return time.Duration(rand.Int64N(int64(backoff)) + 1)
+}
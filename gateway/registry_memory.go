@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"sync"
+)
+
+// MemoryRegistry keeps the node mappings in process memory. Nodes are
+// considered healthy as long as they are registered with an address, which
+// makes it suitable for tests and single-box setups without Consul.
+type MemoryRegistry struct {
+	mappings map[string]*NodeMapping
+	mu       sync.RWMutex
+}
+
+// NewMemoryRegistry creates an empty in-memory registry
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{mappings: make(map[string]*NodeMapping)}
+}
+
+// GetMapping implements Registry
+func (r *MemoryRegistry) GetMapping(dataID string) (*NodeMapping, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mapping, ok := r.mappings[dataID]
+	if !ok {
+		return nil, nil
+	}
+	return mapping.clone(), nil
+}
+
+// UpdateMapping implements Registry
+func (r *MemoryRegistry) UpdateMapping(dataID string, update func(mapping *NodeMapping) bool) (*NodeMapping, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mapping := &NodeMapping{Version: mappingVersion}
+	if existing, ok := r.mappings[dataID]; ok {
+		mapping = existing.clone()
+	}
+
+	if !update(mapping) {
+		return mapping, nil
+	}
+
+	if len(mapping.Nodes) == 0 {
+		delete(r.mappings, dataID)
+	} else {
+		r.mappings[dataID] = mapping.clone()
+	}
+	return mapping, nil
+}
+
+// ListMappings implements Registry
+func (r *MemoryRegistry) ListMappings() (map[string]*NodeMapping, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mappings := make(map[string]*NodeMapping, len(r.mappings))
+	for dataID, mapping := range r.mappings {
+		mappings[dataID] = mapping.clone()
+	}
+	return mappings, nil
+}
+
+// HealthyNodes implements Registry
+func (r *MemoryRegistry) HealthyNodes() (map[string]NodeInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	healthy := make(map[string]NodeInstance)
+	for _, mapping := range r.mappings {
+		for _, entry := range mapping.Nodes {
+			if entry.Address != "" {
+				healthy[entry.NodeID] = NodeInstance{NodeID: entry.NodeID, Address: entry.Address}
+			}
+		}
+	}
+	return healthy, nil
+}
+
+// Check implements Registry, the memory registry is always available
+func (r *MemoryRegistry) Check() error {
+	return nil
+}
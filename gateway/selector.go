@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the supported node selection strategies
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyRandom             = "random"
+	StrategyLeastActiveStreams = "least_active_streams"
+	StrategyConsistentHash     = "consistent_hash"
+	StrategyWeighted           = "weighted"
+)
+
+var errNoCandidates = errors.New("no candidate nodes")
+
+// Candidate is a node that can serve a data ID
+type Candidate struct {
+	NodeID        string
+	Address       string
+	Weight        int
+	ActiveStreams int64
+}
+
+// Selector picks one node out of the candidates registered for a data ID.
+// The key is an optional client-supplied value used by key-aware strategies.
+type Selector interface {
+	Name() string
+	Select(dataID, key string, candidates []Candidate) (Candidate, error)
+}
+
+// NewSelector creates a selector for the given strategy name
+func NewSelector(strategy string) (Selector, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return newRoundRobinSelector(), nil
+	case StrategyRandom:
+		return randomSelector{}, nil
+	case StrategyLeastActiveStreams:
+		return leastActiveStreamsSelector{}, nil
+	case StrategyConsistentHash:
+		return consistentHashSelector{}, nil
+	case StrategyWeighted:
+		return weightedSelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown selection strategy: %s", strategy)
+	}
+}
+
+// roundRobinSelector cycles through the candidates of each data ID
+type roundRobinSelector struct {
+	// Track the next node index for each data ID
+	indices map[string]*atomic.Uint64
+	mu      sync.RWMutex
+}
+
+func newRoundRobinSelector() *roundRobinSelector {
+	return &roundRobinSelector{indices: make(map[string]*atomic.Uint64)}
+}
+
+func (s *roundRobinSelector) Name() string { return StrategyRoundRobin }
+
+func (s *roundRobinSelector) Select(dataID, key string, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errNoCandidates
+	}
+
+	s.mu.RLock()
+	index, exists := s.indices[dataID]
+	s.mu.RUnlock()
+
+	if !exists {
+		s.mu.Lock()
+		if index, exists = s.indices[dataID]; !exists {
+			index = &atomic.Uint64{}
+			s.indices[dataID] = index
+		}
+		s.mu.Unlock()
+	}
+
+	return candidates[index.Add(1)%uint64(len(candidates))], nil
+}
+
+// randomSelector picks a uniformly random candidate
+type randomSelector struct{}
+
+func (randomSelector) Name() string { return StrategyRandom }
+
+func (randomSelector) Select(dataID, key string, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errNoCandidates
+	}
+	return candidates[rand.IntN(len(candidates))], nil
+}
+
+// leastActiveStreamsSelector picks the candidate serving the fewest streams,
+// breaking ties randomly so equally loaded nodes share new clients
+type leastActiveStreamsSelector struct{}
+
+func (leastActiveStreamsSelector) Name() string { return StrategyLeastActiveStreams }
+
+func (leastActiveStreamsSelector) Select(dataID, key string, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errNoCandidates
+	}
+
+	var best []Candidate
+	for _, c := range candidates {
+		switch {
+		case len(best) == 0 || c.ActiveStreams < best[0].ActiveStreams:
+			best = append(best[:0], c)
+		case c.ActiveStreams == best[0].ActiveStreams:
+			best = append(best, c)
+		}
+	}
+	return best[rand.IntN(len(best))], nil
+}
+
+// consistentHashSelector maps a client key to a node using rendezvous hashing,
+// so a key keeps landing on the same node while that node stays registered.
+// Requests without a key are spread randomly.
+type consistentHashSelector struct{}
+
+func (consistentHashSelector) Name() string { return StrategyConsistentHash }
+
+func (consistentHashSelector) Select(dataID, key string, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errNoCandidates
+	}
+	if key == "" {
+		return randomSelector{}.Select(dataID, key, candidates)
+	}
+
+	var best Candidate
+	var bestScore uint64
+	for i, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(c.NodeID))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best, nil
+}
+
+// weightedSelector picks a random candidate with probability proportional to
+// its weight. Candidates without a positive weight count as weight 1.
+type weightedSelector struct{}
+
+func (weightedSelector) Name() string { return StrategyWeighted }
+
+func (weightedSelector) Select(dataID, key string, candidates []Candidate) (Candidate, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, errNoCandidates
+	}
+
+	total := 0
+	for _, c := range candidates {
+		total += effectiveWeight(c)
+	}
+
+	n := rand.IntN(total)
+	for _, c := range candidates {
+		if n -= effectiveWeight(c); n < 0 {
+			return c, nil
+		}
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+func effectiveWeight(c Candidate) int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
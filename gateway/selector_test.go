@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func testCandidates(nodeIDs ...string) []Candidate {
+	candidates := make([]Candidate, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		candidates[i] = Candidate{NodeID: nodeID}
+	}
+	return candidates
+}
+
+func TestSelectorsRejectNoCandidates(t *testing.T) {
+	for _, strategy := range []string{StrategyRoundRobin, StrategyRandom, StrategyLeastActiveStreams, StrategyConsistentHash, StrategyWeighted} {
+		selector, err := NewSelector(strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := selector.Select("test-data", "key", nil); !errors.Is(err, errNoCandidates) {
+			t.Errorf("%s Select() without candidates = %v, want %v", strategy, err, errNoCandidates)
+		}
+	}
+}
+
+func TestRoundRobinSelectorCycles(t *testing.T) {
+	s := newRoundRobinSelector()
+	candidates := testCandidates("node1", "node2", "node3")
+
+	tests := []struct {
+		name   string
+		dataID string
+	}{
+		{name: "first data ID", dataID: "data-a"},
+		{name: "second data ID cycles on its own", dataID: "data-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var picks []string
+			for i := 0; i < 2*len(candidates); i++ {
+				c, err := s.Select(tt.dataID, "", candidates)
+				if err != nil {
+					t.Fatal(err)
+				}
+				picks = append(picks, c.NodeID)
+			}
+
+			// Every candidate once per cycle, in the same order every cycle
+			first := slices.Clone(picks[:len(candidates)])
+			slices.Sort(first)
+			if !slices.Equal(first, []string{"node1", "node2", "node3"}) {
+				t.Errorf("first cycle picked %v, want every candidate once", picks[:len(candidates)])
+			}
+			if !slices.Equal(picks[:len(candidates)], picks[len(candidates):]) {
+				t.Errorf("cycles picked %v, want the same order twice", picks)
+			}
+		})
+	}
+}
+
+func TestConsistentHashSelectorKeepsKeys(t *testing.T) {
+	s := consistentHashSelector{}
+	candidates := testCandidates("node1", "node2", "node3", "node4")
+
+	placement := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		c, err := s.Select("test-data", key, candidates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		placement[key] = c.NodeID
+	}
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+	}{
+		{name: "same candidates", candidates: candidates},
+		{name: "reordered candidates", candidates: testCandidates("node4", "node2", "node1", "node3")},
+		{name: "node2 removed", candidates: testCandidates("node1", "node3", "node4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, before := range placement {
+				c, err := s.Select("test-data", key, tt.candidates)
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case before == "node2" && !slices.ContainsFunc(tt.candidates, func(c Candidate) bool { return c.NodeID == "node2" }):
+					if c.NodeID == "node2" {
+						t.Errorf("key %s still selects removed node2", key)
+					}
+				case c.NodeID != before:
+					t.Errorf("key %s moved from %s to %s", key, before, c.NodeID)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedSelectorProportions(t *testing.T) {
+	const picks = 60000
+	s := weightedSelector{}
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		want       map[string]float64
+	}{
+		{
+			name:       "positive weights",
+			candidates: []Candidate{{NodeID: "node1", Weight: 3}, {NodeID: "node2", Weight: 1}},
+			want:       map[string]float64{"node1": 0.75, "node2": 0.25},
+		},
+		{
+			name:       "zero and negative weights count as 1",
+			candidates: []Candidate{{NodeID: "node1", Weight: 2}, {NodeID: "node2", Weight: 0}, {NodeID: "node3", Weight: -5}},
+			want:       map[string]float64{"node1": 0.5, "node2": 0.25, "node3": 0.25},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make(map[string]int)
+			for i := 0; i < picks; i++ {
+				c, err := s.Select("test-data", "", tt.candidates)
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[c.NodeID]++
+			}
+			for nodeID, want := range tt.want {
+				if got := float64(counts[nodeID]) / picks; math.Abs(got-want) > 0.02 {
+					t.Errorf("%s picked %.3f of the time, want %.3f", nodeID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLeastActiveStreamsSelector(t *testing.T) {
+	s := leastActiveStreamsSelector{}
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		want       []string
+	}{
+		{
+			name: "lowest count",
+			candidates: []Candidate{
+				{NodeID: "node1", ActiveStreams: 5},
+				{NodeID: "node2", ActiveStreams: 1},
+				{NodeID: "node3", ActiveStreams: 3},
+			},
+			want: []string{"node2"},
+		},
+		{
+			name: "ties share the picks",
+			candidates: []Candidate{
+				{NodeID: "node1", ActiveStreams: 2},
+				{NodeID: "node2", ActiveStreams: 7},
+				{NodeID: "node3", ActiveStreams: 2},
+			},
+			want: []string{"node1", "node3"},
+		},
+		{
+			name:       "single candidate",
+			candidates: []Candidate{{NodeID: "node1", ActiveStreams: 9}},
+			want:       []string{"node1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picked := make(map[string]bool)
+			for i := 0; i < 200; i++ {
+				c, err := s.Select("test-data", "", tt.candidates)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Contains(tt.want, c.NodeID) {
+					t.Fatalf("Select() = %s, want one of %v", c.NodeID, tt.want)
+				}
+				picked[c.NodeID] = true
+			}
+			if len(picked) != len(tt.want) {
+				t.Errorf("Select() picked %v over 200 calls, want every one of %v", picked, tt.want)
+			}
+		})
+	}
+}
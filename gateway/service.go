@@ -2,202 +2,412 @@ package gateway
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"slices"
+	"strconv"
 	"sync"
-	"sync/atomic"
+	"time"
 
-	"github.com/hashicorp/consul/api"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"event-catcher-gateway/config"
 	pb "event-catcher-gateway/proto"
 )
 
+// Service metadata keys published by nodes in their Consul registration
+const (
+	MetaWeight        = "weight"
+	MetaActiveStreams = "active_streams"
+)
+
 // Service implements the Gateway gRPC service
 type Service struct {
 	pb.UnimplementedGatewayServer
-	consulClient *api.Client
-	kvPrefix     string
-	whitelist    map[string]bool
-	mu           sync.RWMutex
-	// Track the next node index for each data ID for round-robin selection
-	nodeIndices map[string]*atomic.Uint64
-	indicesMu   sync.RWMutex
+	registry Registry
+	// Consul KV the whitelist is loaded from below whitelistPrefix, unset
+	// when the whitelist comes from the config file
+	kv              kvStore
+	whitelistPrefix string
+	whitelist       map[string]bool
+	mu              sync.RWMutex
+	// Node selection strategy, with optional per data ID overrides
+	selector  Selector
+	selectors map[string]Selector
+	// Verifies the identity of registering nodes
+	authenticator Authenticator
+	// Operator identity allowed to unregister any node, none when empty
+	adminID string
+	// Node leases renewed by heartbeats, nil when registrations don't expire
+	leases Leases
 }
 
-// NewService creates a new gateway service instance
-func NewService(consulAddr, kvPrefix string) (*Service, error) {
-	config := api.DefaultConfig()
-	config.Address = consulAddr
+// NewService creates a new gateway service instance using the registry
+// backend selected in the configuration
+func NewService(cfg *config.Config) (*Service, error) {
+	registry, err := NewRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewServiceWithRegistry(cfg, registry)
+}
 
-	client, err := api.NewClient(config)
+// NewServiceWithRegistry creates a new gateway service instance on top of
+// the given registry
+func NewServiceWithRegistry(cfg *config.Config, registry Registry) (*Service, error) {
+	selector, err := NewSelector(cfg.Gateway.Selection.Strategy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Consul client: %v", err)
+		return nil, err
 	}
 
-	// Initialize whitelist with hardcoded nodes
-	whitelist := map[string]bool{
-		"node1": true,
-		"node2": true,
-		"node3": true,
+	selectors := make(map[string]Selector)
+	for _, override := range cfg.Gateway.Selection.Overrides {
+		sel, err := NewSelector(override.Strategy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strategy for data ID %s: %w", override.DataID, err)
+		}
+		selectors[override.DataID] = sel
 	}
 
-	return &Service{
-		consulClient: client,
-		kvPrefix:     kvPrefix,
-		whitelist:    whitelist,
-		nodeIndices:  make(map[string]*atomic.Uint64),
-	}, nil
+	authenticator, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	leases, err := NewLeases(cfg, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		registry:      registry,
+		selector:      selector,
+		selectors:     selectors,
+		authenticator: authenticator,
+		adminID:       cfg.Auth.AdminID,
+		leases:        leases,
+	}
+
+	// Initialize the whitelist from Consul KV when a prefix is configured,
+	// otherwise from the node list in the config file
+	if cfg.Gateway.Whitelist.KVPrefix != "" {
+		consulRegistry, ok := consulBackend(registry)
+		if !ok {
+			return nil, fmt.Errorf("gateway.whitelist.kv_prefix requires the %s registry", RegistryConsul)
+		}
+		s.kv = consulRegistry.kv
+		s.whitelistPrefix = cfg.Gateway.Whitelist.KVPrefix
+
+		nodeIDs, _, err := s.loadWhitelist(nil)
+		if err != nil {
+			log.Printf("Failed to load whitelist from Consul KV, will retry in the background: %v", err)
+		}
+		s.setWhitelist(nodeIDs)
+	} else {
+		s.setWhitelist(cfg.Gateway.Whitelist.Nodes)
+	}
+
+	return s, nil
+}
+
+// Start runs the background tasks of the service until the context is cancelled
+func (s *Service) Start(ctx context.Context) {
+	if cache, ok := s.registry.(*CachedRegistry); ok {
+		go cache.Run(ctx)
+	}
+	if s.whitelistPrefix != "" {
+		go s.watchWhitelist(ctx)
+	}
+	if s.leases != nil {
+		go s.reapExpired(ctx)
+	}
 }
 
 // RegisterNode implements the RegisterNode RPC method
 func (s *Service) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest) (*pb.RegisterNodeResponse, error) {
-	// Check if the node is in the whitelist
-	s.mu.RLock()
-	isWhitelisted := s.whitelist[req.NodeId]
-	s.mu.RUnlock()
+	// Check that the caller is the node it claims to be
+	if err := s.authenticator.Authenticate(ctx, req.NodeId); err != nil {
+		log.Printf("Node registration rejected: %s failed authentication: %v", req.NodeId, err)
+		return nil, err
+	}
+	if err := s.checkNotAdmin(req.NodeId); err != nil {
+		log.Printf("Node registration rejected: %v", err)
+		return nil, err
+	}
 
-	if !isWhitelisted {
+	// Check if the node is in the whitelist
+	if !s.isWhitelisted(req.NodeId) {
 		log.Printf("Node registration rejected: %s is not in the whitelist", req.NodeId)
 		return nil, status.Errorf(codes.PermissionDenied, "node %s is not in the whitelist", req.NodeId)
 	}
 
-	// Get existing nodes for this data ID
-	kvPair, _, err := s.consulClient.KV().Get(s.kvPrefix+req.DataId, nil)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to query Consul KV store: %v", err)
+	role := req.Role
+	if role == "" {
+		role = RolePrimary
 	}
-
-	var nodeList []string
-	if kvPair != nil {
-		// Parse existing nodes
-		nodeList = parseNodeList(string(kvPair.Value))
+	if role != RolePrimary && role != RoleReplica {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid role %q, expected %s or %s", req.Role, RolePrimary, RoleReplica)
 	}
 
-	// Check if node is already registered
-	for _, node := range nodeList {
-		if node == req.NodeId {
-			log.Printf("Node %s already registered for data ID %s", req.NodeId, req.DataId)
-			return &pb.RegisterNodeResponse{
-				Success: true,
-				Message: fmt.Sprintf("Node %s already registered for data ID %s", req.NodeId, req.DataId),
-			}, nil
+	// The lease has to be in place before the node enters the mapping,
+	// otherwise the registration could be expired right away
+	newLease := false
+	if s.leases != nil {
+		var err error
+		if newLease, err = s.leases.Grant(req.NodeId); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to grant lease: %v", err)
 		}
 	}
 
-	// Add the new node to the list
-	nodeList = append(nodeList, req.NodeId)
+	entry := NodeEntry{
+		NodeID:       req.NodeId,
+		Address:      req.NodeAddress,
+		Weight:       int(req.Weight),
+		RegisteredAt: time.Now().UTC(),
+		Role:         role,
+		Labels:       req.Labels,
+	}
 
-	// Store the updated node list in Consul
-	_, err = s.consulClient.KV().Put(&api.KVPair{
-		Key:   s.kvPrefix + req.DataId,
-		Value: []byte(serializeNodeList(nodeList)),
-	}, nil)
+	// Add the node to the mapping unless it is already registered with the
+	// same details. Re-registering keeps the original registration time.
+	// With a new lease the entry is written even when unchanged, so the
+	// lease reaper removing the node's expired registration concurrently
+	// conflicts with this write instead of winning over it.
+	alreadyRegistered, updated := false, false
+	mapping, err := s.registry.UpdateMapping(req.DataId, func(mapping *NodeMapping) bool {
+		existing, found := mapping.Get(req.NodeId)
+		alreadyRegistered, updated = found, false
+		if found {
+			same := existing.sameAttributes(entry)
+			if same && !newLease {
+				return false
+			}
+			updated = !same
+			if !existing.RegisteredAt.IsZero() {
+				entry.RegisteredAt = existing.RegisteredAt
+			}
+		}
+		mapping.Put(entry)
+		return true
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to store node mapping: %v", err)
+		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	// Initialize the node index for round-robin if it doesn't exist
-	s.indicesMu.Lock()
-	if _, exists := s.nodeIndices[req.DataId]; !exists {
-		s.nodeIndices[req.DataId] = &atomic.Uint64{}
+	if updated {
+		log.Printf("Node %s registration for data ID %s updated", req.NodeId, req.DataId)
+		return &pb.RegisterNodeResponse{
+			Success: true,
+			Message: fmt.Sprintf("Node %s registration for data ID %s updated", req.NodeId, req.DataId),
+		}, nil
 	}
-	s.indicesMu.Unlock()
 
-	log.Printf("Node %s registered for data ID %s (total nodes: %d)", req.NodeId, req.DataId, len(nodeList))
+	if alreadyRegistered {
+		log.Printf("Node %s already registered for data ID %s", req.NodeId, req.DataId)
+		return &pb.RegisterNodeResponse{
+			Success: true,
+			Message: fmt.Sprintf("Node %s already registered for data ID %s", req.NodeId, req.DataId),
+		}, nil
+	}
+
+	log.Printf("Node %s registered for data ID %s (total nodes: %d)", req.NodeId, req.DataId, len(mapping.Nodes))
 	return &pb.RegisterNodeResponse{
 		Success: true,
-		Message: fmt.Sprintf("Node %s successfully registered for data ID %s (total nodes: %d)", req.NodeId, req.DataId, len(nodeList)),
+		Message: fmt.Sprintf("Node %s successfully registered for data ID %s (total nodes: %d)", req.NodeId, req.DataId, len(mapping.Nodes)),
 	}, nil
 }
 
 // GetNodeForData implements the GetNodeForData RPC method
 func (s *Service) GetNodeForData(ctx context.Context, req *pb.GetNodeRequest) (*pb.GetNodeResponse, error) {
-	// Query Consul KV store for the node IDs associated with the data ID
-	kvPair, _, err := s.consulClient.KV().Get(s.kvPrefix+req.DataId, nil)
+	// Query Consul KV store for the nodes associated with the data ID
+	mapping, err := s.registry.GetMapping(req.DataId)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to query Consul KV store: %v", err)
+		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
-	if kvPair == nil {
+	if mapping == nil || len(mapping.Nodes) == 0 {
 		return nil, status.Errorf(codes.NotFound, "no nodes found for data ID: %s", req.DataId)
 	}
 
-	// Parse the list of nodes
-	nodeList := parseNodeList(string(kvPair.Value))
-	if len(nodeList) == 0 {
-		return nil, status.Errorf(codes.NotFound, "no nodes found for data ID: %s", req.DataId)
+	// Get the healthy service instances to learn node addresses and load
+	healthy, err := s.registry.HealthyNodes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	// Get the next node index for round-robin selection
-	s.indicesMu.RLock()
-	index, exists := s.nodeIndices[req.DataId]
-	s.indicesMu.RUnlock()
+	// Only whitelisted nodes with a healthy instance are eligible, so a node
+	// that is restarting doesn't fail lookups while its peers are healthy
+	candidates := make([]Candidate, 0, len(mapping.Nodes))
+	for _, entry := range mapping.Nodes {
+		instance, isHealthy := healthy[entry.NodeID]
+		if !isHealthy || !s.isWhitelisted(entry.NodeID) {
+			continue
+		}
+		candidates = append(candidates, newCandidate(entry, instance))
+	}
 
-	if !exists {
-		// Initialize the index if it doesn't exist
-		s.indicesMu.Lock()
-		index = &atomic.Uint64{}
-		s.nodeIndices[req.DataId] = index
-		s.indicesMu.Unlock()
+	if len(candidates) == 0 {
+		return nil, status.Errorf(codes.Unavailable, "none of the %d nodes registered for data ID %s are healthy", len(mapping.Nodes), req.DataId)
 	}
 
-	// Get the next node index using atomic operations
-	nodeIndex := index.Add(1) % uint64(len(nodeList))
-	nodeID := nodeList[nodeIndex]
+	// Pick a node using the strategy configured for this data ID
+	selector := s.selectorFor(req.DataId)
+	selected, err := selector.Select(req.DataId, req.ClientKey, candidates)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to select node: %v", err)
+	}
+	nodeID := selected.NodeID
 
-	// Check if the node is in the whitelist
-	s.mu.RLock()
-	isWhitelisted := s.whitelist[nodeID]
-	s.mu.RUnlock()
+	log.Printf("Selected node %s at %s for data ID %s (strategy: %s, candidates: %d)",
+		nodeID, selected.Address, req.DataId, selector.Name(), len(candidates))
+	return &pb.GetNodeResponse{
+		NodeAddress: selected.Address,
+		NodeId:      nodeID,
+	}, nil
+}
 
-	if !isWhitelisted {
-		return nil, status.Errorf(codes.PermissionDenied, "node %s is not in the whitelist", nodeID)
+// UnregisterNode implements the UnregisterNode RPC method
+func (s *Service) UnregisterNode(ctx context.Context, req *pb.UnregisterNodeRequest) (*pb.UnregisterNodeResponse, error) {
+	// The node itself may remove its registration, and so may the operator
+	// identity, to drain or decommission a node or to clean up after one
+	// that crashed
+	if err := s.authenticateNodeOrAdmin(ctx, req.NodeId); err != nil {
+		log.Printf("Node unregistration rejected: caller is neither %s nor the operator: %v", req.NodeId, err)
+		return nil, err
 	}
 
-	// Get the healthy service instance for this node
-	services, _, err := s.consulClient.Health().Service("streaming-node", "", true, nil)
+	// Remove the node from the mapping, dropping the mapping entirely once
+	// its last node is gone
+	wasRegistered := false
+	mapping, err := s.registry.UpdateMapping(req.DataId, func(mapping *NodeMapping) bool {
+		wasRegistered = mapping.Remove(req.NodeId)
+		return wasRegistered
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to query Consul service catalog: %v", err)
+		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	// Find the specific node in the healthy services
-	var nodeAddress string
-	for _, service := range services {
-		if service.Service.ID == nodeID {
-			nodeAddress = fmt.Sprintf("%s:%d", service.Service.Address, service.Service.Port)
-			break
-		}
+	if !wasRegistered {
+		return &pb.UnregisterNodeResponse{
+			Success: true,
+			Message: fmt.Sprintf("Node %s is not registered for data ID %s", req.NodeId, req.DataId),
+		}, nil
 	}
 
-	if nodeAddress == "" {
-		return nil, status.Errorf(codes.Unavailable, "node %s is not healthy or not found", nodeID)
+	log.Printf("Node %s unregistered from data ID %s (remaining nodes: %d)", req.NodeId, req.DataId, len(mapping.Nodes))
+	return &pb.UnregisterNodeResponse{
+		Success: true,
+		Message: fmt.Sprintf("Node %s unregistered from data ID %s (remaining nodes: %d)", req.NodeId, req.DataId, len(mapping.Nodes)),
+	}, nil
+}
+
+// Heartbeat implements the Heartbeat RPC method. It renews the node's lease;
+// a node whose lease already expired gets NotFound and must register again.
+func (s *Service) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if err := s.authenticator.Authenticate(ctx, req.NodeId); err != nil {
+		return nil, err
+	}
+	if err := s.checkNotAdmin(req.NodeId); err != nil {
+		return nil, err
+	}
+	if s.leases == nil {
+		return &pb.HeartbeatResponse{Success: true}, nil
 	}
 
-	log.Printf("Selected node %s at %s for data ID %s (using round-robin, index: %d/%d)",
-		nodeID, nodeAddress, req.DataId, nodeIndex+1, len(nodeList))
-	return &pb.GetNodeResponse{
-		NodeAddress: nodeAddress,
-		NodeId:      nodeID,
+	err := s.leases.Renew(req.NodeId)
+	if errors.Is(err, errLeaseNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s has no lease, register again", req.NodeId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	return &pb.HeartbeatResponse{
+		Success:         true,
+		LeaseTtlSeconds: int64(s.leases.TTL() / time.Second),
 	}, nil
 }
 
-// Helper function to parse a comma-separated list of node IDs
-func parseNodeList(value string) []string {
-	if value == "" {
-		return []string{}
+// ListNodesForData implements the ListNodesForData RPC method
+func (s *Service) ListNodesForData(ctx context.Context, req *pb.ListNodesForDataRequest) (*pb.ListNodesForDataResponse, error) {
+	mapping, err := s.registry.GetMapping(req.DataId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	if mapping == nil {
+		return nil, status.Errorf(codes.NotFound, "no nodes found for data ID: %s", req.DataId)
 	}
 
-	// Split by comma and trim whitespace
-	nodes := strings.Split(value, ",")
-	for i, node := range nodes {
-		nodes[i] = strings.TrimSpace(node)
+	healthy, err := s.registry.HealthyNodes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
-	return nodes
+
+	resp := &pb.ListNodesForDataResponse{}
+	for _, entry := range mapping.Nodes {
+		info := &pb.NodeInfo{
+			NodeId: entry.NodeID,
+			Weight: int32(entry.Weight),
+			Role:   entry.Role,
+			Labels: entry.Labels,
+		}
+		if !entry.RegisteredAt.IsZero() {
+			info.RegisteredAt = entry.RegisteredAt.Unix()
+		}
+		if instance, ok := healthy[entry.NodeID]; ok {
+			info.NodeAddress = instance.Address
+			info.Healthy = true
+		}
+		resp.Nodes = append(resp.Nodes, info)
+	}
+	return resp, nil
+}
+
+// ListDataForNode implements the ListDataForNode RPC method
+func (s *Service) ListDataForNode(ctx context.Context, req *pb.ListDataForNodeRequest) (*pb.ListDataForNodeResponse, error) {
+	mappings, err := s.registry.ListMappings()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	resp := &pb.ListDataForNodeResponse{}
+	for dataID, mapping := range mappings {
+		if _, found := mapping.Get(req.NodeId); found {
+			resp.DataIds = append(resp.DataIds, dataID)
+		}
+	}
+	slices.Sort(resp.DataIds)
+	return resp, nil
+}
+
+// Check reports whether the gateway can serve lookups and registrations,
+// which requires its registry backend to be reachable
+func (s *Service) Check() error {
+	return s.registry.Check()
+}
+
+// selectorFor returns the selector configured for a data ID
+func (s *Service) selectorFor(dataID string) Selector {
+	if selector, ok := s.selectors[dataID]; ok {
+		return selector
+	}
+	return s.selector
 }
 
-// Helper function to serialize a list of node IDs to a comma-separated string
-func serializeNodeList(nodes []string) string {
-	return strings.Join(nodes, ",")
+// newCandidate builds a selection candidate from a node's mapping entry and
+// its healthy instance. A weight recorded in the mapping takes precedence
+// over the weight the node publishes in its service metadata.
+func newCandidate(entry NodeEntry, instance NodeInstance) Candidate {
+	candidate := Candidate{
+		NodeID:  entry.NodeID,
+		Address: instance.Address,
+		Weight:  entry.Weight,
+	}
+	if weight, err := strconv.Atoi(instance.Meta[MetaWeight]); err == nil && candidate.Weight <= 0 {
+		candidate.Weight = weight
+	}
+	if streams, err := strconv.ParseInt(instance.Meta[MetaActiveStreams], 10, 64); err == nil {
+		candidate.ActiveStreams = streams
+	}
+	return candidate
 }
@@ -0,0 +1,304 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "event-catcher-gateway/proto"
+)
+
+// fakeKV is an in-memory kvStore with Consul's ModifyIndex semantics
+type fakeKV struct {
+	mu    sync.Mutex
+	index uint64
+	pairs map[string]*api.KVPair
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{pairs: make(map[string]*api.KVPair)}
+}
+
+func (kv *fakeKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	// Yield so concurrent read-modify-write cycles interleave
+	defer runtime.Gosched()
+
+	pair, ok := kv.pairs[key]
+	if !ok {
+		return nil, &api.QueryMeta{LastIndex: kv.index}, nil
+	}
+	copied := *pair
+	copied.Value = slices.Clone(pair.Value)
+	return &copied, &api.QueryMeta{LastIndex: kv.index}, nil
+}
+
+func (kv *fakeKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	var pairs api.KVPairs
+	for key, pair := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			copied := *pair
+			pairs = append(pairs, &copied)
+		}
+	}
+	return pairs, &api.QueryMeta{LastIndex: kv.index}, nil
+}
+
+func (kv *fakeKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	var current uint64
+	if pair, ok := kv.pairs[p.Key]; ok {
+		current = pair.ModifyIndex
+	}
+	if current != p.ModifyIndex {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	kv.index++
+	kv.pairs[p.Key] = &api.KVPair{Key: p.Key, Value: slices.Clone(p.Value), ModifyIndex: kv.index}
+	return true, &api.WriteMeta{}, nil
+}
+
+func (kv *fakeKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	pair, ok := kv.pairs[p.Key]
+	if !ok || pair.ModifyIndex != p.ModifyIndex {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	kv.index++
+	delete(kv.pairs, p.Key)
+	return true, &api.WriteMeta{}, nil
+}
+
+func newTestService(registry Registry, nodeIDs []string) *Service {
+	s := &Service{
+		registry:      registry,
+		selector:      newRoundRobinSelector(),
+		authenticator: noAuthenticator{},
+	}
+	s.setWhitelist(nodeIDs)
+	return s
+}
+
+func newConsulTestRegistry(kv kvStore) *ConsulRegistry {
+	return &ConsulRegistry{kv: kv, kvPrefix: "streaming/data/"}
+}
+
+// testRegistries are the registry backends the service tests run against
+var testRegistries = []struct {
+	name string
+	new  func() Registry
+}{
+	{name: RegistryConsul, new: func() Registry { return newConsulTestRegistry(newFakeKV()) }},
+	{name: RegistryMemory, new: func() Registry { return NewMemoryRegistry() }},
+}
+
+func TestConcurrentRegistrationsKeepAllNodes(t *testing.T) {
+	const nodeCount = 100
+
+	nodeIDs := make([]string, nodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node%d", i)
+	}
+
+	for _, backend := range testRegistries {
+		t.Run(backend.name, func(t *testing.T) {
+			s := newTestService(backend.new(), nodeIDs)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, nodeCount)
+			for _, nodeID := range nodeIDs {
+				wg.Add(1)
+				go func(nodeID string) {
+					defer wg.Done()
+					_, err := s.RegisterNode(context.Background(), &pb.RegisterNodeRequest{NodeId: nodeID, DataId: "test-data"})
+					errs <- err
+				}(nodeID)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err != nil {
+					t.Fatalf("RegisterNode failed: %v", err)
+				}
+			}
+
+			mapping, err := s.registry.GetMapping("test-data")
+			if err != nil {
+				t.Fatalf("invalid node mapping: %v", err)
+			}
+			if mapping == nil {
+				t.Fatal("node mapping was not stored")
+			}
+
+			registered := mapping.NodeIDs()
+			slices.Sort(registered)
+			expected := slices.Clone(nodeIDs)
+			slices.Sort(expected)
+			if !slices.Equal(registered, expected) {
+				t.Fatalf("expected %d registered nodes, got %d: %v", len(expected), len(registered), registered)
+			}
+		})
+	}
+}
+
+func TestConcurrentUnregistrationsRemoveAllNodes(t *testing.T) {
+	const nodeCount = 50
+
+	nodeIDs := make([]string, nodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node%d", i)
+	}
+
+	for _, backend := range testRegistries {
+		t.Run(backend.name, func(t *testing.T) {
+			s := newTestService(backend.new(), nodeIDs)
+			for _, nodeID := range nodeIDs {
+				if _, err := s.RegisterNode(context.Background(), &pb.RegisterNodeRequest{NodeId: nodeID, DataId: "test-data"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			unregisterConcurrently(t, s, nodeIDs)
+
+			if mapping, err := s.registry.GetMapping("test-data"); err != nil || mapping != nil {
+				t.Fatalf("expected mapping to be deleted, got %v, %v", mapping, err)
+			}
+		})
+	}
+}
+
+func TestConcurrentUnregistrationsConvertLegacyMapping(t *testing.T) {
+	const nodeCount = 50
+
+	nodeIDs := make([]string, nodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node%d", i)
+	}
+
+	kv := newFakeKV()
+	// Start from a mapping in the legacy comma-separated format
+	kv.CAS(&api.KVPair{Key: "streaming/data/test-data", Value: []byte(strings.Join(nodeIDs, ","))}, nil)
+	s := newTestService(newConsulTestRegistry(kv), nodeIDs)
+
+	unregisterConcurrently(t, s, nodeIDs)
+
+	if pair, _, _ := kv.Get("streaming/data/test-data", nil); pair != nil {
+		t.Fatalf("expected mapping to be deleted, got %q", pair.Value)
+	}
+}
+
+func unregisterConcurrently(t *testing.T, s *Service, nodeIDs []string) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, nodeID := range nodeIDs {
+		wg.Add(1)
+		go func(nodeID string) {
+			defer wg.Done()
+			if _, err := s.UnregisterNode(context.Background(), &pb.UnregisterNodeRequest{NodeId: nodeID, DataId: "test-data"}); err != nil {
+				t.Errorf("UnregisterNode failed: %v", err)
+			}
+		}(nodeID)
+	}
+	wg.Wait()
+}
+
+// The Consul registry reads health from the catalog, which has no fake, so
+// lookups are exercised against the memory registry
+func TestRegisterLookupUnregister(t *testing.T) {
+	s := newTestService(NewMemoryRegistry(), []string{"node1", "node2"})
+	ctx := context.Background()
+
+	if _, err := s.GetNodeForData(ctx, &pb.GetNodeRequest{DataId: "test-data"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetNodeForData() before registering = %v, want %v", err, codes.NotFound)
+	}
+
+	if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: "node1", DataId: "test-data", NodeAddress: "10.0.0.1:8081"}); err != nil {
+		t.Fatalf("RegisterNode() = %v", err)
+	}
+	if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: "node3", DataId: "test-data", NodeAddress: "10.0.0.3:8081"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("RegisterNode() of a node not in the whitelist = %v, want %v", err, codes.PermissionDenied)
+	}
+
+	resp, err := s.GetNodeForData(ctx, &pb.GetNodeRequest{DataId: "test-data"})
+	if err != nil {
+		t.Fatalf("GetNodeForData() = %v", err)
+	}
+	if resp.NodeId != "node1" || resp.NodeAddress != "10.0.0.1:8081" {
+		t.Errorf("GetNodeForData() = %s at %s, want node1 at 10.0.0.1:8081", resp.NodeId, resp.NodeAddress)
+	}
+
+	if _, err := s.UnregisterNode(ctx, &pb.UnregisterNodeRequest{NodeId: "node1", DataId: "test-data"}); err != nil {
+		t.Fatalf("UnregisterNode() = %v", err)
+	}
+	if _, err := s.GetNodeForData(ctx, &pb.GetNodeRequest{DataId: "test-data"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetNodeForData() after unregistering = %v, want %v", err, codes.NotFound)
+	}
+}
+
+// healthRegistry overrides which nodes of a registry count as healthy
+type healthRegistry struct {
+	Registry
+	healthy map[string]NodeInstance
+}
+
+func (r healthRegistry) HealthyNodes() (map[string]NodeInstance, error) {
+	return r.healthy, nil
+}
+
+func TestGetNodeForDataSkipsUnhealthyNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		healthy  []string
+		wantNode string
+		wantCode codes.Code
+	}{
+		{name: "one healthy node", healthy: []string{"node2"}, wantNode: "node2", wantCode: codes.OK},
+		{name: "no healthy node", healthy: nil, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := healthRegistry{Registry: NewMemoryRegistry(), healthy: make(map[string]NodeInstance)}
+			for _, nodeID := range tt.healthy {
+				registry.healthy[nodeID] = NodeInstance{NodeID: nodeID, Address: nodeID + ":8081"}
+			}
+			s := newTestService(registry, []string{"node1", "node2"})
+			ctx := context.Background()
+			for _, nodeID := range []string{"node1", "node2"} {
+				if _, err := s.RegisterNode(ctx, &pb.RegisterNodeRequest{NodeId: nodeID, DataId: "test-data", NodeAddress: nodeID + ":8081"}); err != nil {
+					t.Fatalf("RegisterNode(%s) = %v", nodeID, err)
+				}
+			}
+
+			// Round robin would reach node1 within two lookups if it were eligible
+			for i := 0; i < 4; i++ {
+				resp, err := s.GetNodeForData(ctx, &pb.GetNodeRequest{DataId: "test-data"})
+				if code := status.Code(err); code != tt.wantCode {
+					t.Fatalf("GetNodeForData() = %v, want %v", err, tt.wantCode)
+				}
+				if err == nil && resp.NodeId != tt.wantNode {
+					t.Errorf("GetNodeForData() = %s, want %s", resp.NodeId, tt.wantNode)
+				}
+			}
+		})
+	}
+}
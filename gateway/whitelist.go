@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"event-catcher-gateway/consulwatch"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// isWhitelisted reports whether a node is allowed to register and serve data
+func (s *Service) isWhitelisted(nodeID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.whitelist[nodeID]
+}
+
+// setWhitelist replaces the whitelist, logging the nodes admitted and revoked
+func (s *Service) setWhitelist(nodeIDs []string) {
+	whitelist := make(map[string]bool, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		whitelist[nodeID] = true
+	}
+
+	s.mu.Lock()
+	previous := s.whitelist
+	s.whitelist = whitelist
+	s.mu.Unlock()
+
+	for nodeID := range whitelist {
+		if !previous[nodeID] {
+			log.Printf("Node %s admitted to the whitelist", nodeID)
+		}
+	}
+	for nodeID := range previous {
+		if !whitelist[nodeID] {
+			log.Printf("Node %s revoked from the whitelist", nodeID)
+		}
+	}
+}
+
+// loadWhitelist reads the whitelisted node IDs from the Consul KV prefix.
+// Every key below the prefix names one whitelisted node.
+func (s *Service) loadWhitelist(opts *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	pairs, meta, err := s.kv.List(s.whitelistPrefix, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodeIDs := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		nodeID := strings.TrimPrefix(pair.Key, s.whitelistPrefix)
+		if nodeID == "" || strings.HasSuffix(nodeID, "/") {
+			continue
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs, meta, nil
+}
+
+// watchWhitelist keeps the whitelist in sync with the Consul KV prefix using
+// blocking queries until the context is cancelled
+func (s *Service) watchWhitelist(ctx context.Context) {
+	consulwatch.Watch(ctx, consulwatch.MaxWaitTime, s.loadWhitelist,
+		func(nodeIDs []string, changed bool) {
+			if changed {
+				s.setWhitelist(nodeIDs)
+			}
+		},
+		func(err error) {
+			log.Printf("Failed to watch whitelist in Consul KV: %v", err)
+		})
+}
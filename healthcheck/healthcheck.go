@@ -0,0 +1,50 @@
+// Package healthcheck keeps the status reported over the standard gRPC
+// health checking protocol in line with a check of the server's dependencies
+package healthcheck
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Update sets the status of the server as a whole and of the named service
+// from the result of check, returning that result
+func Update(hs *health.Server, service string, check func() error) error {
+	err := check()
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	hs.SetServingStatus("", status)
+	hs.SetServingStatus(service, status)
+	return err
+}
+
+// Watch runs check now and then every interval, updating the status until
+// the context is cancelled and logging every change
+func Watch(ctx context.Context, hs *health.Server, service string, interval time.Duration, check func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := true
+	for {
+		err := Update(hs, service, check)
+		switch {
+		case err != nil && serving:
+			log.Printf("Service %s is not serving: %v", service, err)
+		case err == nil && !serving:
+			log.Printf("Service %s is serving again", service)
+		}
+		serving = err == nil
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"fmt"
+	"net"
+
+	"event-catcher-gateway/config"
+)
+
+// AdvertiseAddress determines the address the node is reached at, which it
+// registers with Consul and the gateway. An explicit node.advertise_address
+// wins. Otherwise it is the first address of an interface that is up,
+// restricted to node.advertise_interface and node.advertise_cidr when they
+// are set, preferring IPv4. Without either, loopback and link-local
+// addresses are skipped and 127.0.0.1 is the last resort.
+func AdvertiseAddress(cfg config.NodeConfig) (string, error) {
+	if cfg.AdvertiseAddress != "" {
+		return cfg.AdvertiseAddress, nil
+	}
+
+	var network *net.IPNet
+	if cfg.AdvertiseCIDR != "" {
+		_, n, err := net.ParseCIDR(cfg.AdvertiseCIDR)
+		if err != nil {
+			return "", fmt.Errorf("invalid node.advertise_cidr: %w", err)
+		}
+		network = n
+	}
+	selected := cfg.AdvertiseInterface != "" || network != nil
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	var ifaceFound bool
+	var ipv6 net.IP
+	for _, iface := range ifaces {
+		if cfg.AdvertiseInterface != "" && iface.Name != cfg.AdvertiseInterface {
+			continue
+		}
+		ifaceFound = true
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP
+			switch {
+			case network != nil && !network.Contains(ip):
+				continue
+			// IPv6 link-local addresses can't be dialed without a zone
+			case ip.IsLinkLocalUnicast() && (!selected || ip.To4() == nil):
+				continue
+			case ip.IsLoopback() && !selected:
+				continue
+			}
+			if ip.To4() != nil {
+				return ip.String(), nil
+			}
+			if ipv6 == nil {
+				ipv6 = ip
+			}
+		}
+	}
+	if ipv6 != nil {
+		return ipv6.String(), nil
+	}
+
+	switch {
+	case cfg.AdvertiseInterface != "" && !ifaceFound:
+		return "", fmt.Errorf("no network interface named %q", cfg.AdvertiseInterface)
+	case cfg.AdvertiseInterface != "" && network != nil:
+		return "", fmt.Errorf("interface %s has no address in %s", cfg.AdvertiseInterface, cfg.AdvertiseCIDR)
+	case cfg.AdvertiseInterface != "":
+		return "", fmt.Errorf("interface %s has no usable address", cfg.AdvertiseInterface)
+	case selected:
+		return "", fmt.Errorf("no interface has an address in %s", cfg.AdvertiseCIDR)
+	default:
+		return "127.0.0.1", nil
+	}
+}
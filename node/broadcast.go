@@ -0,0 +1,252 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delay before the pump retries after failing to read its source
+const pumpRetryDelay = time.Second
+
+// Subscription receives the live events of a data ID through a bounded
+// buffer. What happens when the buffer overflows depends on the policy of
+// the data ID: either live events are dropped, or the subscriber is told to
+// catch up from the source instead.
+type Subscription struct {
+	id       string
+	events   chan Event
+	overflow chan struct{}
+	// Offset of the next event the subscriber will process
+	position atomic.Int64
+	once     sync.Once
+	b        *Broadcaster
+}
+
+// Events returns the channel of live events. It is closed when the
+// broadcaster shuts down.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Overflow returns a channel signalled when live events were dropped
+// because the buffer was full
+func (s *Subscription) Overflow() <-chan struct{} {
+	return s.overflow
+}
+
+// SetPosition records the offset of the next event the subscriber will process
+func (s *Subscription) SetPosition(offset int64) {
+	s.position.Store(offset)
+}
+
+// Lag returns how many events the subscriber is behind the head of the
+// source. Events dropped by the policy are never delivered and don't count.
+func (s *Subscription) Lag() int64 {
+	if s.b.policy.drops() {
+		return int64(len(s.events))
+	}
+	return max(s.b.head.Load()-s.position.Load(), 0)
+}
+
+// Close unsubscribes from the broadcaster
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.b.unsubscribe(s)
+	})
+}
+
+// Broadcaster reads the source of one data ID once and fans the new events
+// out to all subscribers, so the cost of following the head doesn't grow
+// with the number of subscribers
+type Broadcaster struct {
+	dataID     string
+	source     Source
+	bufferSize int
+	policy     StreamPolicy
+	// Offset following the last event fanned out
+	head atomic.Int64
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	nextID uint64
+	closed bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewBroadcaster starts fanning out the events appended to the source from
+// now on. bufferSize is the number of live events buffered per subscriber
+// and policy decides what happens when a buffer is full.
+func NewBroadcaster(dataID string, source Source, bufferSize int, policy StreamPolicy) (*Broadcaster, error) {
+	head, err := source.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broadcaster{
+		dataID:     dataID,
+		source:     source,
+		bufferSize: bufferSize,
+		policy:     policy,
+		subs:       make(map[*Subscription]struct{}),
+		cancel:     cancel,
+	}
+	b.head.Store(head)
+
+	b.wg.Add(1)
+	go b.pump(ctx, head)
+	return b, nil
+}
+
+// Policy returns the slow-consumer policy of the broadcaster
+func (b *Broadcaster) Policy() StreamPolicy {
+	return b.policy
+}
+
+// Subscribe registers a new subscriber for live events. The name identifies
+// the subscriber in lag reports.
+func (b *Broadcaster) Subscribe(name string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errSourceClosed
+	}
+
+	b.nextID++
+	sub := &Subscription{
+		id:       fmt.Sprintf("%s#%d", name, b.nextID),
+		events:   make(chan Event, b.bufferSize),
+		overflow: make(chan struct{}, 1),
+		b:        b,
+	}
+	sub.position.Store(b.head.Load())
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Lags returns how far each subscriber is behind the head, by subscriber ID
+func (b *Broadcaster) Lags() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lags := make(map[string]int64, len(b.subs))
+	for sub := range b.subs {
+		lags[sub.id] = sub.Lag()
+	}
+	return lags
+}
+
+func (b *Broadcaster) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+}
+
+// pump follows the head of the source and delivers every new event to the
+// current subscribers
+func (b *Broadcaster) pump(ctx context.Context, head int64) {
+	defer b.wg.Done()
+
+	for {
+		events, err := b.source.Read(head, readBatchSize)
+		var outOfRange *OffsetOutOfRangeError
+		if errors.As(err, &outOfRange) {
+			// Retention overtook the pump, continue from the oldest event
+			head = outOfRange.Oldest
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to read events of data ID %s for broadcast: %v", b.dataID, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pumpRetryDelay):
+			}
+			continue
+		}
+
+		if len(events) == 0 {
+			if err := b.source.Wait(ctx, head); err != nil {
+				if ctx.Err() != nil || errors.Is(err, errSourceClosed) {
+					return
+				}
+				log.Printf("Failed to wait for events of data ID %s: %v", b.dataID, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(pumpRetryDelay):
+				}
+			}
+			continue
+		}
+
+		b.mu.Lock()
+		for _, event := range events {
+			for sub := range b.subs {
+				b.deliver(sub, event)
+			}
+		}
+		head = events[len(events)-1].Offset + 1
+		b.head.Store(head)
+		b.mu.Unlock()
+	}
+}
+
+// deliver hands an event to a subscriber without blocking the other
+// subscribers, applying the slow-consumer policy when its buffer is full.
+// Callers must hold the lock.
+func (b *Broadcaster) deliver(sub *Subscription, event Event) {
+	select {
+	case sub.events <- event:
+		return
+	default:
+	}
+
+	switch b.policy.Name {
+	case PolicyDropNewest:
+	case PolicyDropOldest:
+		// Only the pump sends, so after making room the send succeeds
+		select {
+		case <-sub.events:
+		default:
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	default:
+		select {
+		case sub.overflow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close stops the broadcaster and closes the event channels of all subscribers
+func (b *Broadcaster) Close() {
+	b.cancel()
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.events)
+		delete(b.subs, sub)
+	}
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/offsetstore"
+	pb "event-catcher-gateway/proto"
+)
+
+// NewOffsetStore creates the store of committed consumer group offsets
+// configured in cfg. Offsets are kept as the position to resume from, one
+// past the last event committed.
+func NewOffsetStore(cfg *config.Config) (offsetstore.Store, error) {
+	store := cfg.Node.Offsets.Store
+	if store == "" {
+		store = offsetstore.StoreConsul
+	}
+	return offsetstore.New(store, cfg.Node.Offsets.Dir, cfg.GetConsulAddr(), cfg.Node.Offsets.KVPrefix)
+}
+
+// ValidateConsumerGroup checks that a consumer group name can safely be
+// used as a file name by the file offset store
+func ValidateConsumerGroup(group string) error {
+	if group == "" {
+		return fmt.Errorf("consumer group is required")
+	}
+	if !dataIDPattern.MatchString(group) || group == "." || group == ".." {
+		return fmt.Errorf("invalid consumer group %q: only letters, digits, '.', '_' and '-' are allowed", group)
+	}
+	return nil
+}
+
+// CommitOffset implements the CommitOffset RPC method. The last commit of a
+// group wins, so a group member that commits an older offset rewinds the
+// group and the events after it are delivered again.
+func (s *Service) CommitOffset(ctx context.Context, req *pb.CommitOffsetRequest) (*pb.CommitOffsetResponse, error) {
+	if err := ValidateConsumerGroup(req.ConsumerGroup); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if req.Offset < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "offset must not be negative: %d", req.Offset)
+	}
+	if err := ValidateDataID(req.DataId); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.checkServed(req.DataId); err != nil {
+		return nil, err
+	}
+
+	source, err := s.sources.Get(req.DataId)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	next, err := source.Next()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read head of data ID %s: %v", req.DataId, err)
+	}
+	if req.Offset >= next {
+		return nil, status.Errorf(codes.InvalidArgument, "offset %d of data ID %s has not been appended yet", req.Offset, req.DataId)
+	}
+
+	if err := s.offsets.Save(ctx, req.ConsumerGroup, req.DataId, req.Offset+1); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit offset: %v", err)
+	}
+
+	log.Printf("Consumer group %s committed offset %d of data ID %s", req.ConsumerGroup, req.Offset, req.DataId)
+	return &pb.CommitOffsetResponse{Success: true}, nil
+}
+
+// startOffset returns the offset a stream starts from: right after the
+// committed offset of its consumer group, or the requested offset when the
+// group has not committed yet
+func (s *Service) startOffset(ctx context.Context, req *pb.StreamRequest) (int64, error) {
+	if req.ConsumerGroup == "" {
+		return req.Offset, nil
+	}
+	if err := ValidateConsumerGroup(req.ConsumerGroup); err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	offset, ok, err := s.offsets.Load(ctx, req.ConsumerGroup, req.DataId)
+	if err != nil {
+		return 0, status.Errorf(codes.Unavailable, "failed to load offset of consumer group %s: %v", req.ConsumerGroup, err)
+	}
+	if !ok {
+		return req.Offset, nil
+	}
+
+	log.Printf("Consumer group %s resumes data ID %s from offset %d", req.ConsumerGroup, req.DataId, offset)
+	return offset, nil
+}
@@ -0,0 +1,50 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/offsetstore"
+	pb "event-catcher-gateway/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateConsumerGroup(t *testing.T) {
+	tests := []struct {
+		group   string
+		wantErr bool
+	}{
+		{group: "billing"},
+		{group: "billing-v2.1_a"},
+		{group: "", wantErr: true},
+		{group: ".", wantErr: true},
+		{group: "..", wantErr: true},
+		{group: "../etc", wantErr: true},
+		{group: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateConsumerGroup(tt.group); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateConsumerGroup(%q) = %v, want error %v", tt.group, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCommitOffsetRejectsUnsafeConsumerGroup(t *testing.T) {
+	offsets, err := offsetstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestService(t, config.StreamConfig{Policy: PolicyBlock, BufferSize: 16}, offsets)
+
+	_, err = s.CommitOffset(context.Background(), &pb.CommitOffsetRequest{
+		DataId:        "test-data",
+		ConsumerGroup: "..",
+		Offset:        0,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CommitOffset with group \"..\" = %v, want InvalidArgument", err)
+	}
+}
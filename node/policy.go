@@ -0,0 +1,71 @@
+package node
+
+import (
+	"fmt"
+
+	"event-catcher-gateway/config"
+)
+
+// Names of the supported slow-consumer policies
+const (
+	// PolicyBlock makes the stream wait for its client, reading the events
+	// it missed back from the source so nothing is lost
+	PolicyBlock = "block"
+	// PolicyDropOldest discards the oldest buffered live event to make room
+	PolicyDropOldest = "drop_oldest"
+	// PolicyDropNewest discards new live events while the buffer is full
+	PolicyDropNewest = "drop_newest"
+	// PolicyDisconnect behaves like PolicyBlock but ends the stream with
+	// ResourceExhausted once it falls more than MaxLag events behind the head
+	PolicyDisconnect = "disconnect"
+)
+
+// StreamPolicy decides what happens to streams that can't keep up with the
+// live events of a data ID
+type StreamPolicy struct {
+	Name   string
+	MaxLag int64
+}
+
+// NewStreamPolicy validates a slow-consumer policy
+func NewStreamPolicy(name string, maxLag int64) (StreamPolicy, error) {
+	switch name {
+	case "":
+		name = PolicyBlock
+	case PolicyBlock, PolicyDropOldest, PolicyDropNewest:
+	case PolicyDisconnect:
+		if maxLag <= 0 {
+			return StreamPolicy{}, fmt.Errorf("policy %s requires a positive max_lag", name)
+		}
+	default:
+		return StreamPolicy{}, fmt.Errorf("unknown slow-consumer policy: %s", name)
+	}
+	return StreamPolicy{Name: name, MaxLag: maxLag}, nil
+}
+
+// drops reports whether the policy lets streams skip live events
+func (p StreamPolicy) drops() bool {
+	return p.Name == PolicyDropOldest || p.Name == PolicyDropNewest
+}
+
+// newStreamPolicies builds the default policy and the per data ID overrides
+func newStreamPolicies(cfg config.StreamConfig) (StreamPolicy, map[string]StreamPolicy, error) {
+	policy, err := NewStreamPolicy(cfg.Policy, cfg.MaxLag)
+	if err != nil {
+		return StreamPolicy{}, nil, err
+	}
+
+	overrides := make(map[string]StreamPolicy, len(cfg.Overrides))
+	for _, override := range cfg.Overrides {
+		maxLag := override.MaxLag
+		if maxLag == 0 {
+			maxLag = cfg.MaxLag
+		}
+		p, err := NewStreamPolicy(override.Policy, maxLag)
+		if err != nil {
+			return StreamPolicy{}, nil, fmt.Errorf("invalid policy for data ID %s: %w", override.DataID, err)
+		}
+		overrides[override.DataID] = p
+	}
+	return policy, overrides, nil
+}
@@ -0,0 +1,175 @@
+package node
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "event-catcher-gateway/proto"
+)
+
+// producerKey identifies a producer writing to a data ID
+type producerKey struct {
+	dataID     string
+	producerID string
+}
+
+// producerWindow remembers the offsets assigned to the most recent
+// idempotency keys of one producer
+type producerWindow struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	// Keys in insertion order, oldest first, for eviction
+	order *list.List
+	// Last time the producer published, guarded by the deduplicator's lock
+	lastUsed time.Time
+}
+
+// deduplicator makes publishing idempotent per producer. It remembers the
+// offsets of the last window idempotency keys of every producer in memory,
+// so a retried event is only appended once while the node keeps running.
+// Nothing is persisted: after a restart, events published before it are
+// appended again when retried. Producers idle for longer than producerTTL
+// are forgotten, so retries after that are appended again as well.
+type deduplicator struct {
+	window      int
+	producerTTL time.Duration
+	mu          sync.Mutex
+	producers   map[producerKey]*producerWindow
+	lastSweep   time.Time
+}
+
+func newDeduplicator(window int, producerTTL time.Duration) *deduplicator {
+	return &deduplicator{
+		window:      window,
+		producerTTL: producerTTL,
+		producers:   make(map[producerKey]*producerWindow),
+		lastSweep:   time.Now(),
+	}
+}
+
+// producer returns the window of a producer, creating it on its first
+// event. Producers idle for longer than the TTL are evicted while at it, at
+// most once per TTL so publishing doesn't scan every producer.
+func (d *deduplicator) producer(key producerKey, now time.Time) *producerWindow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= d.producerTTL {
+		for k, w := range d.producers {
+			if now.Sub(w.lastUsed) > d.producerTTL {
+				delete(d.producers, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	w, ok := d.producers[key]
+	if !ok {
+		w = &producerWindow{offsets: make(map[string]int64), order: list.New()}
+		d.producers[key] = w
+	}
+	w.lastUsed = now
+	return w
+}
+
+// append appends the event unless the producer already published an event
+// with the same idempotency key, in which case the original offset is
+// returned. Events without a producer ID or key are always appended.
+func (d *deduplicator) append(source Source, dataID string, event *pb.PublishRequest) (int64, bool, error) {
+	if event.ProducerId == "" || event.IdempotencyKey == "" || d.window <= 0 {
+		offset, err := source.Append(event.Data)
+		return offset, false, err
+	}
+
+	w := d.producer(producerKey{dataID: dataID, producerID: event.ProducerId}, time.Now())
+
+	// Hold the producer's lock across the append so concurrent retries of
+	// the same event can't both be appended
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if offset, ok := w.offsets[event.IdempotencyKey]; ok {
+		return offset, true, nil
+	}
+
+	offset, err := source.Append(event.Data)
+	if err != nil {
+		return 0, false, err
+	}
+
+	w.offsets[event.IdempotencyKey] = offset
+	w.order.PushBack(event.IdempotencyKey)
+	for w.order.Len() > d.window {
+		oldest := w.order.Remove(w.order.Front()).(string)
+		delete(w.offsets, oldest)
+	}
+	return offset, false, nil
+}
+
+// publish appends one event to the source of its data ID
+func (s *Service) publish(event *pb.PublishRequest) (int64, bool, error) {
+	if err := ValidateDataID(event.DataId); err != nil {
+		return 0, false, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.checkServed(event.DataId); err != nil {
+		return 0, false, err
+	}
+
+	source, err := s.sources.Get(event.DataId)
+	if err != nil {
+		return 0, false, status.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	offset, duplicate, err := s.dedup.append(source, event.DataId, event)
+	if err != nil {
+		return 0, false, status.Errorf(codes.Internal, "failed to append event to data ID %s: %v", event.DataId, err)
+	}
+	return offset, duplicate, nil
+}
+
+// Publish implements the Publish RPC method
+func (s *Service) Publish(stream pb.Node_PublishServer) error {
+	resp := &pb.PublishResponse{}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+
+		offset, duplicate, err := s.publish(event)
+		if err != nil {
+			return err
+		}
+		resp.Offsets = append(resp.Offsets, offset)
+		if duplicate {
+			resp.Duplicates++
+		}
+	}
+}
+
+// PublishBatch implements the PublishBatch RPC method. Events are appended
+// in order; on failure the events before the failing one stay appended and
+// can be retried safely with idempotency keys.
+func (s *Service) PublishBatch(ctx context.Context, req *pb.PublishBatchRequest) (*pb.PublishResponse, error) {
+	resp := &pb.PublishResponse{Offsets: make([]int64, 0, len(req.Events))}
+	for _, event := range req.Events {
+		offset, duplicate, err := s.publish(event)
+		if err != nil {
+			return nil, err
+		}
+		resp.Offsets = append(resp.Offsets, offset)
+		if duplicate {
+			resp.Duplicates++
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	pb "event-catcher-gateway/proto"
+)
+
+func TestDeduplicatorDropsRetriedEvents(t *testing.T) {
+	d := newDeduplicator(2, time.Hour)
+	source := NewRingBuffer(100)
+	defer source.Close()
+
+	publish := func(key string) (int64, bool) {
+		t.Helper()
+		offset, duplicate, err := d.append(source, "data", &pb.PublishRequest{ProducerId: "p1", IdempotencyKey: key, Data: []byte(key)})
+		if err != nil {
+			t.Fatalf("append(%s) = %v", key, err)
+		}
+		return offset, duplicate
+	}
+
+	first, _ := publish("a")
+	if offset, duplicate := publish("a"); !duplicate || offset != first {
+		t.Errorf("append(a) again = %d, %v, want %d, true", offset, duplicate, first)
+	}
+
+	// Pushing two more keys through the window of 2 forgets the first
+	publish("b")
+	publish("c")
+	if _, duplicate := publish("a"); duplicate {
+		t.Errorf("append(a) after leaving the window reported a duplicate")
+	}
+}
+
+func TestDeduplicatorEvictsIdleProducers(t *testing.T) {
+	const ttl = time.Minute
+	d := newDeduplicator(10, ttl)
+	start := d.lastSweep
+
+	idle := producerKey{dataID: "data", producerID: "idle"}
+	active := producerKey{dataID: "data", producerID: "active"}
+	d.producer(idle, start)
+	d.producer(active, start)
+
+	d.producer(active, start.Add(ttl/2))
+	d.producer(active, start.Add(ttl+time.Second))
+
+	if _, ok := d.producers[idle]; ok {
+		t.Errorf("producer idle for longer than the TTL was not evicted")
+	}
+	if _, ok := d.producers[active]; !ok {
+		t.Errorf("active producer was evicted")
+	}
+}
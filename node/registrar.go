@@ -0,0 +1,227 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/config"
+	pb "event-catcher-gateway/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Registrar keeps the node registered with the gateway for the data IDs it
+// serves
+type Registrar struct {
+	gateway pb.GatewayClient
+	cfg     config.NodeConfig
+	address string
+	served  *ServedDataIDs
+
+	heartbeatInterval time.Duration
+	reconcileInterval time.Duration
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+
+	mu         sync.Mutex
+	registered map[string]bool
+}
+
+// NewRegistrar creates a registrar registering the node at address for the
+// served data IDs
+func NewRegistrar(gateway pb.GatewayClient, cfg config.NodeConfig, address string, served *ServedDataIDs) (*Registrar, error) {
+	r := &Registrar{
+		gateway:    gateway,
+		cfg:        cfg,
+		address:    address,
+		served:     served,
+		registered: make(map[string]bool),
+	}
+
+	intervals := []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"node.heartbeat_interval", cfg.HeartbeatInterval, &r.heartbeatInterval},
+		{"node.registration.reconcile_interval", cfg.Registration.ReconcileInterval, &r.reconcileInterval},
+		{"node.registration.initial_backoff", cfg.Registration.InitialBackoff, &r.initialBackoff},
+		{"node.registration.max_backoff", cfg.Registration.MaxBackoff, &r.maxBackoff},
+	}
+	for _, interval := range intervals {
+		d, err := time.ParseDuration(interval.value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", interval.name, interval.value)
+		}
+		*interval.d = d
+	}
+	r.maxBackoff = max(r.maxBackoff, r.initialBackoff)
+	return r, nil
+}
+
+// Sync registers the data IDs served and not yet registered, and
+// unregisters those no longer served. Data IDs that fail are retried on the
+// next Sync.
+func (r *Registrar) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dataIDs, err := r.served.List()
+	if err != nil && len(dataIDs) == 0 {
+		return err
+	}
+	errs := []error{err}
+
+	served := make(map[string]bool, len(dataIDs))
+	for _, dataID := range dataIDs {
+		served[dataID] = true
+		if r.registered[dataID] {
+			continue
+		}
+		if err := r.register(ctx, dataID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.registered[dataID] = true
+	}
+
+	// Patterns may match nothing while the data IDs can't be listed, so
+	// only unregister once they could be
+	if err == nil {
+		for dataID := range r.registered {
+			if served[dataID] {
+				continue
+			}
+			if err := r.unregister(ctx, dataID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(r.registered, dataID)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registrar) register(ctx context.Context, dataID string) error {
+	_, err := r.gateway.RegisterNode(ctx, &pb.RegisterNodeRequest{
+		NodeId:      r.cfg.ID,
+		DataId:      dataID,
+		Weight:      int32(r.cfg.Weight),
+		Role:        r.cfg.Role,
+		Labels:      r.cfg.Labels,
+		NodeAddress: r.address,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register data ID %s with gateway: %w", dataID, err)
+	}
+	log.Printf("Registered with gateway for data ID %s", dataID)
+	return nil
+}
+
+func (r *Registrar) unregister(ctx context.Context, dataID string) error {
+	_, err := r.gateway.UnregisterNode(ctx, &pb.UnregisterNodeRequest{
+		NodeId: r.cfg.ID,
+		DataId: dataID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unregister data ID %s from gateway: %w", dataID, err)
+	}
+	log.Printf("Unregistered from gateway for data ID %s", dataID)
+	return nil
+}
+
+// reconcile compares the registrations with those the gateway lists for
+// the node. Registrations it lost, for instance by restarting with an
+// in-memory registry, are made again by the next Sync, and those it still
+// has for data IDs no longer served, for instance from before the node
+// restarted, are removed by it.
+func (r *Registrar) reconcile(ctx context.Context) error {
+	resp, err := r.gateway.ListDataForNode(ctx, &pb.ListDataForNodeRequest{NodeId: r.cfg.ID})
+	if err != nil {
+		return fmt.Errorf("failed to list registrations with gateway: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listed := make(map[string]bool, len(resp.DataIds))
+	for _, dataID := range resp.DataIds {
+		listed[dataID] = true
+		r.registered[dataID] = true
+	}
+	for dataID := range r.registered {
+		if !listed[dataID] {
+			log.Printf("Gateway lost the registration for data ID %s, registering again", dataID)
+			delete(r.registered, dataID)
+		}
+	}
+	return nil
+}
+
+// Run registers the node in the background, retrying with exponential
+// backoff until the gateway accepts every registration. It then renews the
+// node's lease every heartbeat interval, registering everything again when
+// the lease expired, and reconciles the registrations with the gateway
+// every reconcile interval. Each round syncs the registrations, which also
+// picks up data IDs newly matching a pattern.
+func (r *Registrar) Run(ctx context.Context) {
+	heartbeat := time.NewTicker(r.heartbeatInterval)
+	defer heartbeat.Stop()
+	reconcile := time.NewTicker(r.reconcileInterval)
+	defer reconcile.Stop()
+
+	// Pending while a failed sync waits for its retry, during which the
+	// tickers don't sync so the backoff holds
+	retry := time.After(0)
+	backoff := r.initialBackoff
+	attempt := func() {
+		if err := r.Sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay := rand.N(backoff) + 1
+			log.Printf("Registration with gateway failed, retrying in %v: %v", delay, err)
+			retry = time.After(delay)
+			backoff = min(backoff*2, r.maxBackoff)
+			return
+		}
+		retry = nil
+		backoff = r.initialBackoff
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry:
+			attempt()
+		case <-heartbeat.C:
+			_, err := r.gateway.Heartbeat(ctx, &pb.HeartbeatRequest{NodeId: r.cfg.ID})
+			switch {
+			case status.Code(err) == codes.NotFound:
+				log.Printf("Lease with the gateway expired, registering again")
+				r.mu.Lock()
+				clear(r.registered)
+				r.mu.Unlock()
+			case err != nil && ctx.Err() == nil:
+				log.Printf("Failed to send heartbeat to gateway: %v", err)
+			}
+			if retry == nil {
+				attempt()
+			}
+		case <-reconcile.C:
+			if err := r.reconcile(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%v", err)
+			}
+			if retry == nil {
+				attempt()
+			}
+		}
+	}
+}
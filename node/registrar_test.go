@@ -0,0 +1,210 @@
+package node
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/config"
+	pb "event-catcher-gateway/proto"
+)
+
+// fakeGateway keeps the registrations of a single node
+type fakeGateway struct {
+	pb.GatewayClient
+
+	mu         sync.Mutex
+	registered map[string]bool
+	// Number of RegisterNode calls left to fail
+	failures int
+	// Whether the node's lease expired, which the next Heartbeat reports
+	expired bool
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{registered: make(map[string]bool)}
+}
+
+func (g *fakeGateway) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest, opts ...grpc.CallOption) (*pb.RegisterNodeResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures > 0 {
+		g.failures--
+		return nil, status.Error(codes.Unavailable, "gateway unavailable")
+	}
+	g.registered[req.DataId] = true
+	return &pb.RegisterNodeResponse{Success: true}, nil
+}
+
+func (g *fakeGateway) UnregisterNode(ctx context.Context, req *pb.UnregisterNodeRequest, opts ...grpc.CallOption) (*pb.UnregisterNodeResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.registered, req.DataId)
+	return &pb.UnregisterNodeResponse{Success: true}, nil
+}
+
+func (g *fakeGateway) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest, opts ...grpc.CallOption) (*pb.HeartbeatResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.expired {
+		g.expired = false
+		return nil, status.Error(codes.NotFound, "no lease for node")
+	}
+	return &pb.HeartbeatResponse{}, nil
+}
+
+func (g *fakeGateway) ListDataForNode(ctx context.Context, req *pb.ListDataForNodeRequest, opts ...grpc.CallOption) (*pb.ListDataForNodeResponse, error) {
+	return &pb.ListDataForNodeResponse{DataIds: g.dataIDs()}, nil
+}
+
+// expire drops the node's registrations as an expired lease does
+func (g *fakeGateway) expire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.registered)
+	g.expired = true
+}
+
+func (g *fakeGateway) dataIDs() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dataIDs := make([]string, 0, len(g.registered))
+	for dataID := range g.registered {
+		dataIDs = append(dataIDs, dataID)
+	}
+	slices.Sort(dataIDs)
+	return dataIDs
+}
+
+// waitForDataIDs waits until the gateway has exactly the given registrations
+func (g *fakeGateway) waitForDataIDs(t *testing.T, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(g.dataIDs(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("gateway registrations = %v, want %v", g.dataIDs(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testNodeConfig(dataIDs ...string) config.NodeConfig {
+	return config.NodeConfig{
+		ID:                "node1",
+		DataIDs:           dataIDs,
+		HeartbeatInterval: "10ms",
+		Registration: config.NodeRegistrationConfig{
+			ReconcileInterval: "1h",
+			InitialBackoff:    "1ms",
+			MaxBackoff:        "5ms",
+		},
+	}
+}
+
+func newTestRegistrar(t *testing.T, gateway pb.GatewayClient, cfg config.NodeConfig) (*Registrar, *ServedDataIDs) {
+	t.Helper()
+	served, err := NewServedDataIDs(cfg, func() ([]string, error) { return nil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistrar(gateway, cfg, "10.0.0.1:8081", served)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, served
+}
+
+// runRegistrar runs the registrar until the test ends
+func runRegistrar(t *testing.T, r *Registrar) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRegistrarRetriesUntilRegistered(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.failures = 5
+	r, _ := newTestRegistrar(t, gateway, testNodeConfig("data-a", "data-b"))
+
+	runRegistrar(t, r)
+	gateway.waitForDataIDs(t, "data-a", "data-b")
+}
+
+func TestRegistrarRegistersAgainAfterLeaseExpired(t *testing.T) {
+	gateway := newFakeGateway()
+	r, _ := newTestRegistrar(t, gateway, testNodeConfig("data-a", "data-b"))
+
+	runRegistrar(t, r)
+	gateway.waitForDataIDs(t, "data-a", "data-b")
+
+	// The registrar still counts the data IDs as registered, only the
+	// heartbeat tells it they are gone
+	gateway.expire()
+	gateway.waitForDataIDs(t, "data-a", "data-b")
+}
+
+func TestRegistrarUnregistersDataIDsNoLongerServed(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// Registrations the gateway has before the first Sync
+		before []string
+		// Data IDs served at the first and second Sync
+		first, second []string
+		want          []string
+	}{
+		{
+			name:   "data ID removed from the config",
+			first:  []string{"data-a", "data-b"},
+			second: []string{"data-a"},
+			want:   []string{"data-a"},
+		},
+		{
+			name:   "registration left from before a restart",
+			before: []string{"data-old"},
+			first:  []string{"data-a"},
+			second: []string{"data-a"},
+			want:   []string{"data-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newFakeGateway()
+			for _, dataID := range tt.before {
+				gateway.registered[dataID] = true
+			}
+			r, served := newTestRegistrar(t, gateway, testNodeConfig(tt.first...))
+
+			// The registrar learns of earlier registrations by reconciling
+			if err := r.reconcile(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := served.Update(testNodeConfig(tt.second...)); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := gateway.dataIDs(); !slices.Equal(got, tt.want) {
+				t.Errorf("gateway registrations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"event-catcher-gateway/config"
+)
+
+// ServedDataIDs holds the data IDs a node serves: the configured data IDs
+// and those matching one of the configured glob patterns
+type ServedDataIDs struct {
+	// Lists the data IDs that patterns are matched against
+	available func() ([]string, error)
+
+	mu       sync.RWMutex
+	dataIDs  map[string]bool
+	patterns []string
+}
+
+// NewServedDataIDs creates the served data IDs of a node configuration
+func NewServedDataIDs(cfg config.NodeConfig, available func() ([]string, error)) (*ServedDataIDs, error) {
+	s := &ServedDataIDs{available: available}
+	if err := s.Update(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the served data IDs and patterns with those of a
+// reloaded configuration
+func (s *ServedDataIDs) Update(cfg config.NodeConfig) error {
+	dataIDs := make(map[string]bool, len(cfg.DataIDs))
+	for _, dataID := range cfg.DataIDs {
+		if err := ValidateDataID(dataID); err != nil {
+			return fmt.Errorf("invalid node.data_ids: %w", err)
+		}
+		dataIDs[dataID] = true
+	}
+	for _, pattern := range cfg.DataIDPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid node.data_id_patterns entry %q: %w", pattern, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dataIDs = dataIDs
+	s.patterns = cfg.DataIDPatterns
+	return nil
+}
+
+// Contains reports whether the node serves a data ID
+func (s *ServedDataIDs) Contains(dataID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.dataIDs[dataID] || s.matches(dataID)
+}
+
+// matches reports whether a data ID matches one of the patterns. Callers
+// must hold the lock.
+func (s *ServedDataIDs) matches(dataID string) bool {
+	for _, pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, dataID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List resolves the data IDs served, matching the patterns against the
+// available data IDs. When these can't be listed, it returns the data IDs
+// resolved without them along with the error.
+func (s *ServedDataIDs) List() ([]string, error) {
+	s.mu.RLock()
+	hasPatterns := len(s.patterns) > 0
+	s.mu.RUnlock()
+
+	var available []string
+	var err error
+	if hasPatterns {
+		available, err = s.available()
+		if err != nil {
+			err = fmt.Errorf("failed to list data IDs matching patterns: %w", err)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	served := make(map[string]bool, len(s.dataIDs))
+	for dataID := range s.dataIDs {
+		served[dataID] = true
+	}
+	for _, dataID := range available {
+		if s.matches(dataID) {
+			served[dataID] = true
+		}
+	}
+
+	dataIDs := make([]string, 0, len(served))
+	for dataID := range served {
+		dataIDs = append(dataIDs, dataID)
+	}
+	sort.Strings(dataIDs)
+	return dataIDs, err
+}
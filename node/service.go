@@ -0,0 +1,377 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/offsetstore"
+	pb "event-catcher-gateway/proto"
+)
+
+// Maximum number of events read from a source at once
+const readBatchSize = 100
+
+// How often the lag of a stream under the disconnect policy is checked
+const lagCheckInterval = 100 * time.Millisecond
+
+// Service implements the Node gRPC service
+type Service struct {
+	pb.UnimplementedNodeServer
+	nodeID  string
+	sources *Sources
+	served  *ServedDataIDs
+	dedup   *deduplicator
+	// Committed consumer group offsets
+	offsets offsetstore.Store
+	// One broadcaster per data ID fans out live events to its streams
+	broadcasters map[string]*Broadcaster
+	bufferSize   int
+	// Slow-consumer policy of the streams, with per data ID overrides
+	policy   StreamPolicy
+	policies map[string]StreamPolicy
+	closed   bool
+	mu       sync.Mutex
+	// Number of streams currently being served, reported to Consul for
+	// least-active-streams selection
+	activeStreams atomic.Int64
+}
+
+// NewService creates a new node service instance serving events from the
+// given sources and keeping consumer group offsets in the given store
+func NewService(cfg *config.Config, sources *Sources, served *ServedDataIDs, offsets offsetstore.Store) (*Service, error) {
+	policy, policies, err := newStreamPolicies(cfg.Node.Stream)
+	if err != nil {
+		return nil, err
+	}
+	producerTTL, err := time.ParseDuration(cfg.Node.Publish.ProducerTTL)
+	if err != nil || producerTTL <= 0 {
+		return nil, fmt.Errorf("invalid node.publish.producer_ttl: %q", cfg.Node.Publish.ProducerTTL)
+	}
+
+	return &Service{
+		nodeID:       cfg.Node.ID,
+		sources:      sources,
+		served:       served,
+		dedup:        newDeduplicator(cfg.Node.Publish.DedupWindow, producerTTL),
+		offsets:      offsets,
+		broadcasters: make(map[string]*Broadcaster),
+		bufferSize:   cfg.Node.Stream.BufferSize,
+		policy:       policy,
+		policies:     policies,
+	}, nil
+}
+
+// Check reports whether the node can serve its data, which requires all of
+// its open sources to be up
+func (s *Service) Check() error {
+	return s.sources.Check()
+}
+
+// ActiveStreams returns the number of streams currently being served
+func (s *Service) ActiveStreams() int64 {
+	return s.activeStreams.Load()
+}
+
+// Close stops fanning out live events. Open streams end with Unavailable.
+func (s *Service) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for dataID, b := range s.broadcasters {
+		b.Close()
+		delete(s.broadcasters, dataID)
+	}
+}
+
+// StreamLags returns how many events each stream is behind the head of its
+// data ID, by data ID and subscriber
+func (s *Service) StreamLags() map[string]map[string]int64 {
+	s.mu.Lock()
+	broadcasters := make(map[string]*Broadcaster, len(s.broadcasters))
+	for dataID, b := range s.broadcasters {
+		broadcasters[dataID] = b
+	}
+	s.mu.Unlock()
+
+	lags := make(map[string]map[string]int64, len(broadcasters))
+	for dataID, b := range broadcasters {
+		lags[dataID] = b.Lags()
+	}
+	return lags
+}
+
+// policyFor returns the slow-consumer policy configured for a data ID
+func (s *Service) policyFor(dataID string) StreamPolicy {
+	if policy, ok := s.policies[dataID]; ok {
+		return policy
+	}
+	return s.policy
+}
+
+// broadcaster returns the broadcaster of a data ID, starting it if needed
+func (s *Service) broadcaster(dataID string, source Source) (*Broadcaster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errSourceClosed
+	}
+	if b, ok := s.broadcasters[dataID]; ok {
+		return b, nil
+	}
+
+	b, err := NewBroadcaster(dataID, source, s.bufferSize, s.policyFor(dataID))
+	if err != nil {
+		return nil, err
+	}
+	s.broadcasters[dataID] = b
+	return b, nil
+}
+
+// checkServed refuses data IDs the node doesn't serve, so a client or
+// producer holding a stale node address goes back to the gateway instead of
+// using a data ID nobody else reads or writes on this node
+func (s *Service) checkServed(dataID string) error {
+	if s.served.Contains(dataID) {
+		return nil
+	}
+	return status.Errorf(codes.NotFound,
+		"node %s does not serve data ID %s, ask the gateway for a node serving it with GetNodeForData", s.nodeID, dataID)
+}
+
+// StreamData implements the StreamData RPC method. It replays the retained
+// events of the data ID from the requested offset and then switches to the
+// live events fanned out by the data ID's broadcaster.
+func (s *Service) StreamData(req *pb.StreamRequest, stream pb.Node_StreamDataServer) error {
+	log.Printf("Starting data stream for data ID: %s from offset: %d", req.DataId, req.Offset)
+
+	if req.Offset < 0 {
+		return status.Errorf(codes.InvalidArgument, "offset must not be negative: %d", req.Offset)
+	}
+	if err := ValidateDataID(req.DataId); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.checkServed(req.DataId); err != nil {
+		return err
+	}
+
+	offset, err := s.startOffset(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.sources.Get(req.DataId)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+	b, err := s.broadcaster(req.DataId, source)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to follow data ID %s: %v", req.DataId, err)
+	}
+
+	// Subscribe before catching up so no event appended in between is missed
+	name := "unknown"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		name = p.Addr.String()
+	}
+	sub, err := b.Subscribe(name)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to follow data ID %s: %v", req.DataId, err)
+	}
+
+	s.activeStreams.Add(1)
+	release := func() {
+		sub.Close()
+		s.activeStreams.Add(-1)
+	}
+
+	st := &streamer{
+		dataID: req.DataId,
+		source: source,
+		sub:    sub,
+		policy: b.Policy(),
+		stream: stream,
+		offset: offset,
+		stop:   make(chan struct{}),
+	}
+	if st.policy.Name == PolicyDisconnect {
+		return st.runWithLagLimit(release)
+	}
+	defer release()
+	return st.run()
+}
+
+// streamer sends the events of one data ID to one client
+type streamer struct {
+	dataID string
+	source Source
+	sub    *Subscription
+	policy StreamPolicy
+	stream pb.Node_StreamDataServer
+	// Offset of the next event to send
+	offset int64
+	// Set once the stream has caught up with the head. The lag limit only
+	// applies from then on so replaying a long history isn't penalised.
+	live atomic.Bool
+	// Closed along with stopped being set when the stream is ended from
+	// outside of run, which then returns errStreamStopped
+	stop    chan struct{}
+	stopped atomic.Bool
+	// Set while run is inside Send
+	sending atomic.Bool
+}
+
+// errStreamStopped is returned by a streamer's run once it was stopped
+var errStreamStopped = errors.New("stream stopped")
+
+func (st *streamer) run() error {
+	ctx := st.stream.Context()
+	st.sub.SetPosition(st.offset)
+	if err := st.catchUp(); err != nil {
+		return err
+	}
+	st.live.Store(true)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+
+		case <-st.stop:
+			return errStreamStopped
+
+		case <-st.sub.Overflow():
+			// Live events were dropped, read them back from the source
+			if err := st.catchUp(); err != nil {
+				return err
+			}
+
+		case event, ok := <-st.sub.Events():
+			if !ok {
+				return status.Errorf(codes.Unavailable, "node is shutting down")
+			}
+			// Under the drop policies gaps are expected and skipped,
+			// otherwise the missing events are read from the source
+			if event.Offset > st.offset && !st.policy.drops() {
+				if err := st.catchUp(); err != nil {
+					return err
+				}
+			}
+			// Skip events already sent while catching up
+			if event.Offset < st.offset {
+				continue
+			}
+			if err := st.send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// catchUp sends the events stored in the source from the current offset up
+// to its head
+func (st *streamer) catchUp() error {
+	for {
+		if st.stopped.Load() {
+			return errStreamStopped
+		}
+		events, err := st.source.Read(st.offset, readBatchSize)
+		if errors.Is(err, ErrOffsetOutOfRange) {
+			return status.Errorf(codes.OutOfRange, "data ID %s: %v", st.dataID, err)
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read events: %v", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			if err := st.send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runWithLagLimit runs the stream and ends it with ResourceExhausted once
+// it falls more than the policy's MaxLag events behind. The lag is checked
+// apart from sending since Send blocks on flow control when the client
+// stops reading. release is called once run has returned.
+//
+// On hitting the limit, run is stopped and waited for, unless it is blocked
+// in Send: only ending the RPC by returning unblocks that Send, after which
+// run returns without touching the stream or the subscription again and
+// releases them itself.
+func (st *streamer) runWithLagLimit(release func()) error {
+	done := make(chan error, 1)
+	go func() {
+		err := st.run()
+		release()
+		done <- err
+	}()
+
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if !st.live.Load() {
+				continue
+			}
+			lag := st.sub.Lag()
+			if lag <= st.policy.MaxLag {
+				continue
+			}
+
+			log.Printf("Disconnecting slow consumer of data ID %s: %d events behind", st.dataID, lag)
+			st.stopped.Store(true)
+			close(st.stop)
+			if !st.sending.Load() {
+				<-done
+			}
+			return status.Errorf(codes.ResourceExhausted,
+				"stream fell %d events behind the head of data ID %s (limit %d)", lag, st.dataID, st.policy.MaxLag)
+		}
+	}
+}
+
+// send sends one event
+func (st *streamer) send(event Event) error {
+	// Checking stopped after marking the send keeps runWithLagLimit from
+	// missing a send that is about to start
+	st.sending.Store(true)
+	if st.stopped.Load() {
+		st.sending.Store(false)
+		return errStreamStopped
+	}
+	err := st.stream.Send(&pb.DataChunk{
+		Data:      event.Data,
+		Offset:    event.Offset,
+		Timestamp: event.Timestamp.Unix(),
+		DataId:    st.dataID,
+	})
+	st.sending.Store(false)
+	if err != nil {
+		return err
+	}
+	if st.stopped.Load() {
+		return errStreamStopped
+	}
+	st.offset = event.Offset + 1
+	st.sub.SetPosition(st.offset)
+	return nil
+}
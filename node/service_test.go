@@ -0,0 +1,142 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"event-catcher-gateway/config"
+	"event-catcher-gateway/offsetstore"
+	pb "event-catcher-gateway/proto"
+)
+
+// stalledStream is a StreamData stream whose client stopped reading: Send
+// blocks like it does on gRPC flow control until the stream ends
+type stalledStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.DataChunk
+}
+
+func (s *stalledStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stalledStream) Send(chunk *pb.DataChunk) error {
+	select {
+	case s.sent <- chunk:
+		return nil
+	case <-s.ctx.Done():
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+// newTestService creates a service serving test-data from a memory source
+func newTestService(t *testing.T, stream config.StreamConfig, offsets offsetstore.Store) (*Service, *Sources) {
+	t.Helper()
+	cfg := &config.Config{Node: config.NodeConfig{
+		ID:      "node1",
+		DataIDs: []string{"test-data"},
+		Stream:  stream,
+		Publish: config.PublishConfig{DedupWindow: 100, ProducerTTL: "1h"},
+	}}
+	served, err := NewServedDataIDs(cfg.Node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := NewSources(func(dataID string) (Source, error) { return NewRingBuffer(1000), nil })
+	s, err := NewService(cfg, sources, served, offsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		sources.Close()
+	})
+	return s, sources
+}
+
+func TestDisconnectPolicyEndsStalledStream(t *testing.T) {
+	s, sources := newTestService(t, config.StreamConfig{Policy: PolicyDisconnect, MaxLag: 5, BufferSize: 4}, offsetstore.NewMemoryStore())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &stalledStream{ctx: ctx, sent: make(chan *pb.DataChunk)}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.StreamData(&pb.StreamRequest{DataId: "test-data"}, stream)
+	}()
+
+	// Wait for the stream to go live, then append more events than the
+	// stalled client may fall behind
+	source, err := sources.Get("test-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s.ActiveStreams() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// An event received means the stream caught up with the head, give it
+	// a moment to find nothing more to read and go live
+	if _, err := source.Append([]byte("event")); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.sent
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if _, err := source.Append([]byte("event")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("StreamData() = %v, want ResourceExhausted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled stream was not disconnected")
+	}
+
+	// The send stays blocked until gRPC ends the RPC, the stream counts as
+	// active until then
+	if n := s.ActiveStreams(); n != 1 {
+		t.Errorf("ActiveStreams() with the send still blocked = %d, want 1", n)
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.ActiveStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream was not released after the RPC ended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUnservedDataIDIsRefused(t *testing.T) {
+	s, _ := newTestService(t, config.StreamConfig{Policy: PolicyBlock, BufferSize: 16}, offsetstore.NewMemoryStore())
+	ctx := context.Background()
+
+	if _, err := s.PublishBatch(ctx, &pb.PublishBatchRequest{
+		Events: []*pb.PublishRequest{{DataId: "test-data", Data: []byte("event")}},
+	}); err != nil {
+		t.Fatalf("PublishBatch to a served data ID = %v", err)
+	}
+
+	_, err := s.PublishBatch(ctx, &pb.PublishBatchRequest{
+		Events: []*pb.PublishRequest{{DataId: "other-data", Data: []byte("event")}},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("PublishBatch to an unserved data ID = %v, want NotFound", err)
+	}
+	if err := s.StreamData(&pb.StreamRequest{DataId: "other-data"}, &stalledStream{ctx: ctx}); status.Code(err) != codes.NotFound {
+		t.Errorf("StreamData of an unserved data ID = %v, want NotFound", err)
+	}
+	if _, err := s.CommitOffset(ctx, &pb.CommitOffsetRequest{DataId: "other-data", ConsumerGroup: "group"}); status.Code(err) != codes.NotFound {
+		t.Errorf("CommitOffset of an unserved data ID = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,271 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"event-catcher-gateway/config"
+)
+
+// Supported event source types
+const (
+	SourceMemory = "memory"
+	SourceFile   = "file"
+	SourceLog    = "log"
+)
+
+// ErrOffsetOutOfRange is returned when reading an offset that is older than
+// the oldest event still retained by a source
+var ErrOffsetOutOfRange = errors.New("offset is no longer retained")
+
+// OffsetOutOfRangeError reports the oldest offset still retained when a
+// read starts before it. It matches ErrOffsetOutOfRange with errors.Is.
+type OffsetOutOfRangeError struct {
+	Offset int64
+	Oldest int64
+}
+
+func (e *OffsetOutOfRangeError) Error() string {
+	return fmt.Sprintf("offset %d is no longer retained, the oldest retained offset is %d", e.Offset, e.Oldest)
+}
+
+func (e *OffsetOutOfRangeError) Is(target error) bool {
+	return target == ErrOffsetOutOfRange
+}
+
+var errSourceClosed = errors.New("source is closed")
+
+// Event is a single event stored in a source
+type Event struct {
+	Offset    int64
+	Timestamp time.Time
+	Data      []byte
+}
+
+// Source holds the events of one data ID. Offsets are assigned
+// sequentially starting at 0, so offset n is always the n-th event appended.
+type Source interface {
+	// Append adds an event and returns the offset assigned to it
+	Append(data []byte) (int64, error)
+
+	// Read returns up to max events starting at offset. It returns no events
+	// when offset is at or past the head, and ErrOffsetOutOfRange when offset
+	// is older than the oldest retained event.
+	Read(offset int64, max int) ([]Event, error)
+
+	// Wait blocks until an event at or after offset is available or the
+	// context is done
+	Wait(ctx context.Context, offset int64) error
+
+	// Next returns the offset the next appended event will be assigned
+	Next() (int64, error)
+
+	// Check reports whether the source can still store and serve events
+	Check() error
+
+	// Close releases the resources held by the source
+	Close() error
+}
+
+// SourceFactory opens the source of a data ID
+type SourceFactory func(dataID string) (Source, error)
+
+// NewSourceFactory creates the factory for the configured source type
+func NewSourceFactory(cfg config.SourceConfig) (SourceFactory, error) {
+	switch cfg.Type {
+	case SourceMemory:
+		if cfg.RingSize <= 0 {
+			return nil, fmt.Errorf("node.source.ring_size must be positive")
+		}
+		return func(dataID string) (Source, error) {
+			return NewRingBuffer(cfg.RingSize), nil
+		}, nil
+	case "", SourceFile:
+		pollInterval, err := time.ParseDuration(cfg.PollInterval)
+		if err != nil || pollInterval <= 0 {
+			return nil, fmt.Errorf("invalid node.source.poll_interval: %q", cfg.PollInterval)
+		}
+		return func(dataID string) (Source, error) {
+			return OpenFileSource(cfg.Dir, dataID, pollInterval)
+		}, nil
+	case SourceLog:
+		opts, err := logOptions(cfg.Log)
+		if err != nil {
+			return nil, err
+		}
+		return func(dataID string) (Source, error) {
+			return OpenSegmentedLog(cfg.Dir, dataID, opts)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown source type: %s", cfg.Type)
+	}
+}
+
+// logOptions converts the segmented log configuration
+func logOptions(cfg config.LogSourceConfig) (LogOptions, error) {
+	opts := LogOptions{
+		SegmentBytes:   cfg.SegmentBytes,
+		RetentionBytes: cfg.RetentionBytes,
+		Fsync:          cfg.Fsync,
+	}
+	if opts.SegmentBytes <= 0 {
+		return opts, fmt.Errorf("node.source.log.segment_bytes must be positive")
+	}
+	if cfg.RetentionAge != "" {
+		age, err := time.ParseDuration(cfg.RetentionAge)
+		if err != nil {
+			return opts, fmt.Errorf("invalid node.source.log.retention_age: %w", err)
+		}
+		opts.RetentionAge = age
+	}
+	return opts, nil
+}
+
+// StoredDataIDs lists the data IDs that have events stored in the source
+// directory. Memory sources store nothing, so there are none for them.
+func StoredDataIDs(cfg config.SourceConfig) ([]string, error) {
+	if cfg.Type == SourceMemory {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source directory: %w", err)
+	}
+
+	var dataIDs []string
+	for _, entry := range entries {
+		dataID := entry.Name()
+		switch {
+		case cfg.Type == SourceLog && entry.IsDir():
+		case cfg.Type != SourceLog && !entry.IsDir() && strings.HasSuffix(dataID, ".log"):
+			dataID = strings.TrimSuffix(dataID, ".log")
+		default:
+			continue
+		}
+		if ValidateDataID(dataID) == nil {
+			dataIDs = append(dataIDs, dataID)
+		}
+	}
+	return dataIDs, nil
+}
+
+var dataIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ValidateDataID checks that a data ID can safely be used as a file name
+func ValidateDataID(dataID string) error {
+	if !dataIDPattern.MatchString(dataID) || dataID == "." || dataID == ".." {
+		return fmt.Errorf("invalid data ID %q: only letters, digits, '.', '_' and '-' are allowed", dataID)
+	}
+	return nil
+}
+
+// Sources opens and keeps one source per data ID
+type Sources struct {
+	factory SourceFactory
+	mu      sync.Mutex
+	sources map[string]Source
+}
+
+// NewSources creates a source set that opens sources with the factory on first use
+func NewSources(factory SourceFactory) *Sources {
+	return &Sources{
+		factory: factory,
+		sources: make(map[string]Source),
+	}
+}
+
+// Get returns the source of a data ID, opening it if needed
+func (s *Sources) Get(dataID string) (Source, error) {
+	if err := ValidateDataID(dataID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if source, ok := s.sources[dataID]; ok {
+		return source, nil
+	}
+
+	source, err := s.factory(dataID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source for data ID %s: %w", dataID, err)
+	}
+	s.sources[dataID] = source
+	return source, nil
+}
+
+// DataIDs lists the data IDs whose source is open
+func (s *Sources) DataIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dataIDs := make([]string, 0, len(s.sources))
+	for dataID := range s.sources {
+		dataIDs = append(dataIDs, dataID)
+	}
+	sort.Strings(dataIDs)
+	return dataIDs
+}
+
+// Check reports the sources that can no longer store or serve events
+func (s *Sources) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for dataID, source := range s.sources {
+		if err := source.Check(); err != nil {
+			errs = append(errs, fmt.Errorf("source for data ID %s is down: %w", dataID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all open sources
+func (s *Sources) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for dataID, source := range s.sources {
+		if err := source.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close source for data ID %s: %w", dataID, err))
+		}
+		delete(s.sources, dataID)
+	}
+	return errors.Join(errs...)
+}
+
+// notifier wakes up goroutines waiting for new events
+type notifier struct {
+	ch chan struct{}
+}
+
+func newNotifier() notifier {
+	return notifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed on the next broadcast. Callers must hold
+// the lock that guards broadcasts.
+func (n *notifier) wait() <-chan struct{} {
+	return n.ch
+}
+
+// broadcast wakes up all current waiters. Callers must hold the lock that
+// guards waits.
+func (n *notifier) broadcast() {
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
@@ -0,0 +1,280 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileSource is an append-only log file holding one event per line. Other
+// processes may append lines to the file as well; new lines are picked up
+// by polling. Offset n maps to the n-th line of the file, which an in-memory
+// index translates to its byte position.
+//
+// Since lines are the record separator, event data must not contain
+// newlines. Lines carry no timestamp, so the timestamp of an event is the
+// time the node indexed it, to the second. The index is rebuilt on open, so
+// after a restart every event already in the file reports the time of the
+// restart.
+type FileSource struct {
+	mu   sync.Mutex
+	file *os.File
+	// Byte position of the start of each complete line
+	positions []int64
+	// Second in which lines were indexed, one run per second in which any
+	// were, so the index doesn't grow by a timestamp per line
+	timestamps []timestampRun
+	// Number of bytes of the file covered by the index
+	indexed      int64
+	pollInterval time.Duration
+	notify       notifier
+	closed       bool
+}
+
+// Size of the buffer new lines are scanned through
+const refreshBufferSize = 64 << 10
+
+// timestampRun records the second in which the lines from offset up to the
+// next run were indexed
+type timestampRun struct {
+	offset int64
+	unix   int64
+}
+
+// OpenFileSource opens or creates the log file of a data ID in dir
+func OpenFileSource(dir, dataID string, pollInterval time.Duration) (*FileSource, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create source directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, dataID+".log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source file: %w", err)
+	}
+
+	f := &FileSource{
+		file:         file,
+		pollInterval: pollInterval,
+		notify:       newNotifier(),
+	}
+	if err := f.refresh(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// refresh indexes the lines appended since the last refresh. Callers must
+// hold the lock.
+func (f *FileSource) refresh() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+
+	size := info.Size()
+	if size < f.indexed {
+		return fmt.Errorf("source file %s was truncated", f.file.Name())
+	}
+	if size == f.indexed {
+		return nil
+	}
+
+	// Scan the new bytes in chunks so indexing a large file doesn't load it
+	// into memory. Only complete lines are indexed, a partially written line
+	// is picked up once its newline arrives.
+	r := bufio.NewReaderSize(io.NewSectionReader(f.file, f.indexed, size-f.indexed), refreshBufferSize)
+	now := time.Now().Unix()
+	start, pos := f.indexed, f.indexed
+	for {
+		chunk, err := r.ReadSlice('\n')
+		pos += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			// The line is longer than the buffer, keep reading it
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read source file: %w", err)
+		}
+
+		if n := len(f.timestamps); n == 0 || f.timestamps[n-1].unix != now {
+			f.timestamps = append(f.timestamps, timestampRun{offset: int64(len(f.positions)), unix: now})
+		}
+		f.positions = append(f.positions, start)
+		start = pos
+	}
+
+	if start > f.indexed {
+		f.indexed = start
+		f.notify.broadcast()
+	}
+	return nil
+}
+
+// Append implements Source
+func (f *FileSource) Append(data []byte) (int64, error) {
+	if bytes.IndexByte(data, '\n') >= 0 {
+		return 0, fmt.Errorf("event data for a file source must not contain newlines")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, errSourceClosed
+	}
+
+	// Index lines appended by other writers first so the offset is exact
+	if err := f.refresh(); err != nil {
+		return 0, err
+	}
+
+	record := make([]byte, 0, len(data)+1)
+	record = append(record, data...)
+	record = append(record, '\n')
+	if _, err := f.file.Write(record); err != nil {
+		return 0, fmt.Errorf("failed to append to source file: %w", err)
+	}
+
+	offset := int64(len(f.positions))
+	if err := f.refresh(); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// Read implements Source
+func (f *FileSource) Read(offset int64, max int) ([]Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, ErrOffsetOutOfRange
+	}
+
+	count := int64(len(f.positions))
+	if offset >= count {
+		return nil, nil
+	}
+
+	end := min(offset+int64(max), count)
+	var endPos int64
+	if end < count {
+		endPos = f.positions[end]
+	} else {
+		endPos = f.indexed
+	}
+
+	buf := make([]byte, endPos-f.positions[offset])
+	if _, err := f.file.ReadAt(buf, f.positions[offset]); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+
+	events := make([]Event, 0, end-offset)
+	base := f.positions[offset]
+	for o := offset; o < end; o++ {
+		lineEnd := endPos
+		if o+1 < count {
+			lineEnd = f.positions[o+1]
+		}
+		events = append(events, Event{
+			Offset:    o,
+			Timestamp: f.timestamp(o),
+			Data:      buf[f.positions[o]-base : lineEnd-base-1],
+		})
+	}
+	return events, nil
+}
+
+// timestamp returns the time the line at offset was indexed. Callers must
+// hold the lock.
+func (f *FileSource) timestamp(offset int64) time.Time {
+	i := sort.Search(len(f.timestamps), func(i int) bool {
+		return f.timestamps[i].offset > offset
+	})
+	return time.Unix(f.timestamps[i-1].unix, 0)
+}
+
+// Wait implements Source. Lines appended by other processes are noticed
+// within the poll interval.
+func (f *FileSource) Wait(ctx context.Context, offset int64) error {
+	ticker := time.NewTicker(f.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			return errSourceClosed
+		}
+		if err := f.refresh(); err != nil {
+			f.mu.Unlock()
+			return err
+		}
+		if offset < int64(len(f.positions)) {
+			f.mu.Unlock()
+			return nil
+		}
+		ch := f.notify.wait()
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Next implements Source
+func (f *FileSource) Next() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.refresh(); err != nil {
+		return 0, err
+	}
+	return int64(len(f.positions)), nil
+}
+
+// Check implements Source. Besides reading the file, it makes sure the file
+// wasn't removed from under the node, since appends would then be lost.
+func (f *FileSource) Check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return errSourceClosed
+	}
+	if _, err := os.Stat(f.file.Name()); err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+	return f.refresh()
+}
+
+// Close implements Source
+func (f *FileSource) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	f.notify.broadcast()
+	return f.file.Close()
+}
@@ -0,0 +1,95 @@
+package node
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSourceIndexesInChunks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test-data.log")
+
+	// Lines straddling buffer boundaries, one longer than the buffer and a
+	// trailing line still being written
+	long := bytes.Repeat([]byte("x"), refreshBufferSize*2+7)
+	var lines [][]byte
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, []byte(time.Duration(i).String()))
+	}
+	lines = append(lines, long, []byte("after"))
+
+	var content []byte
+	for _, line := range lines {
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+	content = append(content, "partial"...)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenFileSource(dir, "test-data", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	next, err := f.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != int64(len(lines)) {
+		t.Fatalf("Next() = %d, want %d", next, len(lines))
+	}
+
+	var got [][]byte
+	for offset := int64(0); offset < next; {
+		events, err := f.Read(offset, 333)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if event.Offset != offset {
+				t.Fatalf("event offset = %d, want %d", event.Offset, offset)
+			}
+			if event.Timestamp.IsZero() {
+				t.Fatalf("event %d has no timestamp", offset)
+			}
+			got = append(got, event.Data)
+			offset++
+		}
+	}
+	for i := range lines {
+		if !bytes.Equal(got[i], lines[i]) {
+			t.Fatalf("event %d = %.20q, want %.20q", i, got[i], lines[i])
+		}
+	}
+
+	// The partial line is indexed once its newline is written
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(" line\n")
+	file.Close()
+
+	events, err := f.Read(next, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || string(events[0].Data) != "partial line" {
+		t.Fatalf("Read after completing the line = %q, want [partial line]", events)
+	}
+
+	// Appends by the node get the next offset
+	offset, err := f.Append([]byte("appended"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != next+1 {
+		t.Errorf("Append() = %d, want %d", offset, next+1)
+	}
+}
@@ -0,0 +1,476 @@
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of a record in a segment file: data length, CRC-32 of the data and
+// the append time in Unix nanoseconds, followed by the data itself
+const (
+	recordHeaderSize = 16
+	indexEntrySize   = 8
+	maxRecordBytes   = 64 << 20
+)
+
+// How often retention is enforced in the background
+const retentionCheckInterval = time.Minute
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// LogOptions configures a segmented log
+type LogOptions struct {
+	// Size at which the active segment is rolled over to a new one
+	SegmentBytes int64
+	// Total size above which the oldest segments are deleted, 0 for no limit
+	RetentionBytes int64
+	// Age after which a segment that is no longer written is deleted, 0 for no limit
+	RetentionAge time.Duration
+	// Sync every append to disk instead of only when rolling a segment
+	Fsync bool
+}
+
+// segment is one file of a segmented log together with its index. Entry i
+// of the index holds the byte position of the record with offset base+i.
+type segment struct {
+	base    int64
+	count   int64
+	size    int64
+	modTime time.Time
+	log     *os.File
+	index   *os.File
+}
+
+// SegmentedLog is a durable source storing the events of a data ID in a
+// directory of segment files. Each segment has an index file mapping
+// offsets to byte positions, so reads can start from any retained offset.
+// Old segments are deleted once the log exceeds its size or age retention,
+// after which their offsets can no longer be read.
+type SegmentedLog struct {
+	dir      string
+	opts     LogOptions
+	mu       sync.Mutex
+	segments []*segment
+	next     int64
+	notify   notifier
+	closed   bool
+	stop     chan struct{}
+}
+
+// OpenSegmentedLog opens or creates the segmented log of a data ID in dir
+func OpenSegmentedLog(dir, dataID string, opts LogOptions) (*SegmentedLog, error) {
+	if opts.SegmentBytes <= 0 {
+		return nil, fmt.Errorf("segment size must be positive")
+	}
+
+	l := &SegmentedLog{
+		dir:    filepath.Join(dir, dataID),
+		opts:   opts,
+		notify: newNotifier(),
+		stop:   make(chan struct{}),
+	}
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := l.load(); err != nil {
+		l.closeSegments()
+		return nil, err
+	}
+
+	go l.enforceRetentionPeriodically()
+	return l, nil
+}
+
+// load opens the existing segments, recovering the active one from a
+// partially written record
+func (l *SegmentedLog) load() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list log directory: %w", err)
+	}
+
+	var bases []int64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".log")
+		if !ok {
+			continue
+		}
+		base, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	for i, base := range bases {
+		seg, err := l.openSegment(base)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, seg)
+
+		if i == len(bases)-1 {
+			if err := recoverSegment(seg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(l.segments) == 0 {
+		seg, err := l.openSegment(0)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, seg)
+	}
+
+	last := l.segments[len(l.segments)-1]
+	l.next = last.base + last.count
+	return nil
+}
+
+func (l *SegmentedLog) segmentPath(base int64, ext string) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+// openSegment opens or creates the files of the segment starting at base
+func (l *SegmentedLog) openSegment(base int64) (*segment, error) {
+	logFile, err := os.OpenFile(l.segmentPath(base, ".log"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+	indexFile, err := os.OpenFile(l.segmentPath(base, ".idx"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("failed to open segment index: %w", err)
+	}
+
+	seg := &segment{base: base, log: logFile, index: indexFile}
+	logInfo, err := logFile.Stat()
+	if err != nil {
+		seg.close()
+		return nil, fmt.Errorf("failed to stat segment: %w", err)
+	}
+	indexInfo, err := indexFile.Stat()
+	if err != nil {
+		seg.close()
+		return nil, fmt.Errorf("failed to stat segment index: %w", err)
+	}
+
+	seg.size = logInfo.Size()
+	seg.modTime = logInfo.ModTime()
+	seg.count = indexInfo.Size() / indexEntrySize
+	return seg, nil
+}
+
+// recoverSegment rebuilds the index of a segment from its records and cuts
+// off a record that was only partially written before a crash
+func recoverSegment(seg *segment) error {
+	var index []byte
+	var pos int64
+	for {
+		_, _, n, err := readRecord(seg.log, pos)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, errCorruptRecord) {
+				return fmt.Errorf("failed to recover segment: %w", err)
+			}
+			break
+		}
+		index = binary.BigEndian.AppendUint64(index, uint64(pos))
+		pos += n
+	}
+
+	if pos < seg.size {
+		log.Printf("Truncating %d bytes of incomplete records from %s", seg.size-pos, seg.log.Name())
+	}
+	if err := seg.log.Truncate(pos); err != nil {
+		return fmt.Errorf("failed to truncate segment: %w", err)
+	}
+	if err := seg.index.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate segment index: %w", err)
+	}
+	if _, err := seg.index.WriteAt(index, 0); err != nil {
+		return fmt.Errorf("failed to rebuild segment index: %w", err)
+	}
+
+	seg.size = pos
+	seg.count = int64(len(index) / indexEntrySize)
+	return nil
+}
+
+// readRecord reads the record at pos, returning its data, timestamp and
+// total size on disk
+func readRecord(r io.ReaderAt, pos int64) ([]byte, time.Time, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := r.ReadAt(header[:], pos); err != nil {
+		return nil, time.Time{}, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	timestamp := time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16])))
+	if length > maxRecordBytes {
+		return nil, time.Time{}, 0, errCorruptRecord
+	}
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, pos+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, time.Time{}, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, time.Time{}, 0, errCorruptRecord
+	}
+	return data, timestamp, recordHeaderSize + int64(length), nil
+}
+
+// Append implements Source
+func (l *SegmentedLog) Append(data []byte) (int64, error) {
+	if len(data) > maxRecordBytes {
+		return 0, fmt.Errorf("event of %d bytes exceeds the maximum of %d bytes", len(data), maxRecordBytes)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, errSourceClosed
+	}
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint64(record[8:16], uint64(time.Now().UnixNano()))
+	copy(record[recordHeaderSize:], data)
+
+	active := l.segments[len(l.segments)-1]
+	if active.count > 0 && active.size+int64(len(record)) > l.opts.SegmentBytes {
+		var err error
+		if active, err = l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := active.log.WriteAt(record, active.size); err != nil {
+		return 0, fmt.Errorf("failed to append to segment: %w", err)
+	}
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:], uint64(active.size))
+	if _, err := active.index.WriteAt(entry[:], active.count*indexEntrySize); err != nil {
+		return 0, fmt.Errorf("failed to append to segment index: %w", err)
+	}
+	if l.opts.Fsync {
+		if err := active.sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	offset := l.next
+	active.size += int64(len(record))
+	active.count++
+	active.modTime = time.Now()
+	l.next++
+
+	l.notify.broadcast()
+	return offset, nil
+}
+
+// roll syncs the active segment and starts a new one at the next offset.
+// Callers must hold the lock.
+func (l *SegmentedLog) roll() (*segment, error) {
+	if err := l.segments[len(l.segments)-1].sync(); err != nil {
+		return nil, err
+	}
+
+	seg, err := l.openSegment(l.next)
+	if err != nil {
+		return nil, err
+	}
+	l.segments = append(l.segments, seg)
+
+	l.enforceRetention()
+	return seg, nil
+}
+
+// enforceRetention deletes the oldest segments that exceed the size or age
+// retention. The active segment is never deleted. Callers must hold the lock.
+func (l *SegmentedLog) enforceRetention() {
+	var total int64
+	for _, seg := range l.segments {
+		total += seg.size
+	}
+
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		tooBig := l.opts.RetentionBytes > 0 && total > l.opts.RetentionBytes
+		tooOld := l.opts.RetentionAge > 0 && time.Since(oldest.modTime) > l.opts.RetentionAge
+		if !tooBig && !tooOld {
+			return
+		}
+
+		oldest.close()
+		if err := os.Remove(l.segmentPath(oldest.base, ".log")); err != nil {
+			log.Printf("Failed to delete segment %s: %v", oldest.log.Name(), err)
+		}
+		if err := os.Remove(l.segmentPath(oldest.base, ".idx")); err != nil {
+			log.Printf("Failed to delete segment index %s: %v", oldest.index.Name(), err)
+		}
+
+		total -= oldest.size
+		l.segments = l.segments[1:]
+		log.Printf("Deleted segment %d of %s, oldest retained offset is now %d", oldest.base, l.dir, l.segments[0].base)
+	}
+}
+
+func (l *SegmentedLog) enforceRetentionPeriodically() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed {
+				l.enforceRetention()
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Read implements Source
+func (l *SegmentedLog) Read(offset int64, max int) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, errSourceClosed
+	}
+	if oldest := l.segments[0].base; offset < oldest {
+		return nil, &OffsetOutOfRangeError{Offset: offset, Oldest: oldest}
+	}
+	if offset >= l.next {
+		return nil, nil
+	}
+
+	// Find the last segment starting at or before the offset
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].base > offset }) - 1
+
+	var events []Event
+	for ; i < len(l.segments) && len(events) < max; i++ {
+		seg := l.segments[i]
+		for o := offset; o < seg.base+seg.count && len(events) < max; o++ {
+			var entry [indexEntrySize]byte
+			if _, err := seg.index.ReadAt(entry[:], (o-seg.base)*indexEntrySize); err != nil {
+				return nil, fmt.Errorf("failed to read segment index: %w", err)
+			}
+			data, timestamp, _, err := readRecord(seg.log, int64(binary.BigEndian.Uint64(entry[:])))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read record %d: %w", o, err)
+			}
+			events = append(events, Event{Offset: o, Timestamp: timestamp, Data: data})
+			offset = o + 1
+		}
+	}
+	return events, nil
+}
+
+// Wait implements Source
+func (l *SegmentedLog) Wait(ctx context.Context, offset int64) error {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return errSourceClosed
+		}
+		if offset < l.next {
+			l.mu.Unlock()
+			return nil
+		}
+		ch := l.notify.wait()
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// Next implements Source
+func (l *SegmentedLog) Next() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next, nil
+}
+
+// Check implements Source. It makes sure the active segment is still in
+// place, since appends would otherwise be lost.
+func (l *SegmentedLog) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errSourceClosed
+	}
+	if _, err := os.Stat(l.segments[len(l.segments)-1].log.Name()); err != nil {
+		return fmt.Errorf("failed to stat active segment: %w", err)
+	}
+	return nil
+}
+
+// Close implements Source
+func (l *SegmentedLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	l.notify.broadcast()
+
+	err := l.segments[len(l.segments)-1].sync()
+	l.closeSegments()
+	return err
+}
+
+func (l *SegmentedLog) closeSegments() {
+	for _, seg := range l.segments {
+		seg.close()
+	}
+}
+
+func (s *segment) sync() error {
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment: %w", err)
+	}
+	if err := s.index.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment index: %w", err)
+	}
+	return nil
+}
+
+func (s *segment) close() {
+	s.log.Close()
+	s.index.Close()
+}
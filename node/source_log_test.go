@@ -0,0 +1,165 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// Each test record takes 16 header bytes plus 8 data bytes, so segments of
+// 50 bytes hold two records
+const testSegmentBytes = 50
+
+func testEvent(offset int64) []byte {
+	return []byte(fmt.Sprintf("event-%02d", offset))
+}
+
+func openTestLog(t *testing.T, dir string, opts LogOptions) *SegmentedLog {
+	t.Helper()
+	l, err := OpenSegmentedLog(dir, "test-data", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendTestEvents(t *testing.T, l *SegmentedLog, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		next, _ := l.Next()
+		offset, err := l.Append(testEvent(next))
+		if err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+		if offset != next {
+			t.Fatalf("Append() = offset %d, want %d", offset, next)
+		}
+	}
+}
+
+// checkEvents reads from offset and checks that it gets want events in order
+func checkEvents(t *testing.T, l *SegmentedLog, offset int64, max, want int) {
+	t.Helper()
+	events, err := l.Read(offset, max)
+	if err != nil {
+		t.Fatalf("Read(%d, %d) = %v", offset, max, err)
+	}
+	if len(events) != want {
+		t.Fatalf("Read(%d, %d) returned %d events, want %d", offset, max, len(events), want)
+	}
+	for i, event := range events {
+		wantOffset := offset + int64(i)
+		if event.Offset != wantOffset || string(event.Data) != string(testEvent(wantOffset)) {
+			t.Errorf("Read(%d, %d)[%d] = offset %d %q, want offset %d %q",
+				offset, max, i, event.Offset, event.Data, wantOffset, testEvent(wantOffset))
+		}
+	}
+}
+
+func TestSegmentedLogRecoversTruncatedRecord(t *testing.T) {
+	var corruptHeader [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(corruptHeader[0:4], 8)
+	binary.BigEndian.PutUint32(corruptHeader[4:8], 12345)
+
+	var longHeader [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(longHeader[0:4], 100)
+
+	tests := []struct {
+		name  string
+		trail []byte
+	}{
+		{name: "partial header", trail: []byte{0, 0, 0}},
+		{name: "partial data", trail: append(longHeader[:], "0123456789"...)},
+		{name: "bad checksum", trail: append(corruptHeader[:], "event-99"...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTestLog(t, dir, LogOptions{SegmentBytes: testSegmentBytes})
+			appendTestEvents(t, l, 3)
+			active := l.segmentPath(l.segments[len(l.segments)-1].base, ".log")
+			l.Close()
+
+			// Simulate a crash in the middle of writing a record
+			f, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(tt.trail); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			l = openTestLog(t, dir, LogOptions{SegmentBytes: testSegmentBytes})
+			if next, _ := l.Next(); next != 3 {
+				t.Fatalf("Next() after reopening = %d, want 3", next)
+			}
+			appendTestEvents(t, l, 1)
+			checkEvents(t, l, 0, 10, 4)
+		})
+	}
+}
+
+func TestSegmentedLogReadsAcrossSegments(t *testing.T) {
+	l := openTestLog(t, t.TempDir(), LogOptions{SegmentBytes: testSegmentBytes})
+	appendTestEvents(t, l, 10)
+
+	if len(l.segments) != 5 {
+		t.Fatalf("log has %d segments, want 5", len(l.segments))
+	}
+	checkEvents(t, l, 0, 100, 10)
+	checkEvents(t, l, 1, 4, 4)
+	checkEvents(t, l, 3, 5, 5)
+	checkEvents(t, l, 9, 100, 1)
+	checkEvents(t, l, 10, 100, 0)
+}
+
+func TestSegmentedLogRetentionMovesOldestOffset(t *testing.T) {
+	// Every roll deletes the oldest segments until at most 100 bytes, two
+	// full segments, remain
+	l := openTestLog(t, t.TempDir(), LogOptions{SegmentBytes: testSegmentBytes, RetentionBytes: 100})
+	appendTestEvents(t, l, 10)
+
+	const oldest = 4
+	_, err := l.Read(0, 10)
+	var outOfRange *OffsetOutOfRangeError
+	if !errors.As(err, &outOfRange) {
+		t.Fatalf("Read(0) = %v, want OffsetOutOfRangeError", err)
+	}
+	if outOfRange.Oldest != oldest {
+		t.Errorf("OffsetOutOfRangeError.Oldest = %d, want %d", outOfRange.Oldest, oldest)
+	}
+	if !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Read(0) = %v, want it to match ErrOffsetOutOfRange", err)
+	}
+	checkEvents(t, l, oldest, 100, 10-oldest)
+}
+
+func TestSegmentedLogKeepsActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, LogOptions{SegmentBytes: testSegmentBytes, RetentionBytes: 1, RetentionAge: time.Nanosecond})
+	appendTestEvents(t, l, 3)
+	time.Sleep(time.Millisecond)
+
+	l.mu.Lock()
+	l.enforceRetention()
+	segments := len(l.segments)
+	base := l.segments[0].base
+	l.mu.Unlock()
+
+	// Every segment exceeds the retention, yet the active one stays
+	if segments != 1 || base != 2 {
+		t.Fatalf("log kept %d segments starting at %d, want the active segment starting at 2", segments, base)
+	}
+	if err := l.Check(); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+	checkEvents(t, l, 2, 10, 1)
+
+	appendTestEvents(t, l, 1)
+	checkEvents(t, l, 2, 10, 2)
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RingBuffer is an in-memory source keeping the most recent events up to a
+// fixed capacity. Older events are evicted and can no longer be read.
+type RingBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	// Offset of the oldest retained event and of the next event to append
+	first, next int64
+	notify      notifier
+	closed      bool
+}
+
+// NewRingBuffer creates a ring buffer holding up to capacity events
+func NewRingBuffer(capacity int) *RingBuffer {
+	return &RingBuffer{
+		events: make([]Event, capacity),
+		notify: newNotifier(),
+	}
+}
+
+// Append implements Source
+func (r *RingBuffer) Append(data []byte) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, errSourceClosed
+	}
+
+	offset := r.next
+	r.events[offset%int64(len(r.events))] = Event{
+		Offset:    offset,
+		Timestamp: time.Now(),
+		Data:      slices.Clone(data),
+	}
+	r.next++
+	if r.next-r.first > int64(len(r.events)) {
+		r.first = r.next - int64(len(r.events))
+	}
+
+	r.notify.broadcast()
+	return offset, nil
+}
+
+// Read implements Source
+func (r *RingBuffer) Read(offset int64, max int) ([]Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if offset < r.first {
+		return nil, &OffsetOutOfRangeError{Offset: offset, Oldest: r.first}
+	}
+
+	var events []Event
+	for o := offset; o < r.next && len(events) < max; o++ {
+		events = append(events, r.events[o%int64(len(r.events))])
+	}
+	return events, nil
+}
+
+// Wait implements Source
+func (r *RingBuffer) Wait(ctx context.Context, offset int64) error {
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return errSourceClosed
+		}
+		if offset < r.next {
+			r.mu.Unlock()
+			return nil
+		}
+		ch := r.notify.wait()
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// Next implements Source
+func (r *RingBuffer) Next() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next, nil
+}
+
+// Check implements Source
+func (r *RingBuffer) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errSourceClosed
+	}
+	return nil
+}
+
+// Close implements Source
+func (r *RingBuffer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		r.notify.broadcast()
+	}
+	return nil
+}
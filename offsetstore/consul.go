@@ -0,0 +1,51 @@
+package offsetstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ConsulStore keeps offsets in Consul KV under
+// <prefix><consumer>/<data ID>, escaped like FileStore's paths, so consumers
+// can move between hosts
+type ConsulStore struct {
+	kv     *api.KV
+	prefix string
+}
+
+// NewConsulStore creates a store under the KV prefix
+func NewConsulStore(client *api.Client, prefix string) *ConsulStore {
+	return &ConsulStore{kv: client.KV(), prefix: prefix}
+}
+
+func (c *ConsulStore) key(consumer, dataID string) string {
+	return c.prefix + key(consumer, dataID)
+}
+
+func (c *ConsulStore) Load(ctx context.Context, consumer, dataID string) (int64, bool, error) {
+	pair, _, err := c.kv.Get(c.key(consumer, dataID), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return 0, false, err
+	}
+	if pair == nil {
+		return 0, false, nil
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(pair.Value)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("corrupt offset of consumer %s in data ID %s: %w", consumer, dataID, err)
+	}
+	return offset, true, nil
+}
+
+func (c *ConsulStore) Save(ctx context.Context, consumer, dataID string, offset int64) error {
+	_, err := c.kv.Put(&api.KVPair{
+		Key:   c.key(consumer, dataID),
+		Value: []byte(strconv.FormatInt(offset, 10)),
+	}, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
@@ -0,0 +1,88 @@
+package offsetstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileStore keeps each offset in its own file, at
+// <dir>/<consumer>/<data ID>, replaced atomically on every save
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a store in dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create offset directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of an offset. Escaping keeps separators out of
+// the consumer and data ID, and "." and ".." are refused since escaping
+// leaves them as they are, so every offset stays inside dir.
+func (f *FileStore) path(consumer, dataID string) (string, error) {
+	for _, name := range []string{consumer, dataID} {
+		switch name {
+		case "", ".", "..":
+			return "", fmt.Errorf("invalid offset path component %q", name)
+		}
+	}
+	return filepath.Join(f.dir, url.PathEscape(consumer), url.PathEscape(dataID)), nil
+}
+
+func (f *FileStore) Load(ctx context.Context, consumer, dataID string) (int64, bool, error) {
+	path, err := f.path(consumer, dataID)
+	if err != nil {
+		return 0, false, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("corrupt offset of consumer %s in data ID %s: %w", consumer, dataID, err)
+	}
+	return offset, true, nil
+}
+
+func (f *FileStore) Save(ctx context.Context, consumer, dataID string, offset int64) error {
+	path, err := f.path(consumer, dataID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it so a crash never leaves a torn offset
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".offset-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatInt(offset, 10) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
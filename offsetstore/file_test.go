@@ -0,0 +1,68 @@
+package offsetstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, ok, err := store.Load(ctx, "group", "test-data"); err != nil || ok {
+		t.Fatalf("Load before Save = ok %v, err %v, want nothing stored", ok, err)
+	}
+	if err := store.Save(ctx, "group", "test-data", 42); err != nil {
+		t.Fatal(err)
+	}
+	offset, ok, err := store.Load(ctx, "group", "test-data")
+	if err != nil || !ok || offset != 42 {
+		t.Fatalf("Load = %d, %v, %v, want 42, true, nil", offset, ok, err)
+	}
+}
+
+func TestFileStoreStaysInsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "offsets")
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		consumer string
+		dataID   string
+		wantErr  bool
+	}{
+		{consumer: "..", dataID: "test-data", wantErr: true},
+		{consumer: ".", dataID: "test-data", wantErr: true},
+		{consumer: "", dataID: "test-data", wantErr: true},
+		{consumer: "group", dataID: "..", wantErr: true},
+		{consumer: "../../etc", dataID: "test-data"},
+		{consumer: "a/b", dataID: "c\\d"},
+	}
+	for _, tt := range tests {
+		err := store.Save(ctx, tt.consumer, tt.dataID, 1)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Save(%q, %q) error = %v, want error %v", tt.consumer, tt.dataID, err, tt.wantErr)
+		}
+		if _, _, err := store.Load(ctx, tt.consumer, tt.dataID); (err != nil) != tt.wantErr {
+			t.Errorf("Load(%q, %q) error = %v, want error %v", tt.consumer, tt.dataID, err, tt.wantErr)
+		}
+	}
+
+	// Nothing may have been written next to the store's directory
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "offsets" {
+		t.Errorf("files written outside the store directory: %v", entries)
+	}
+}
@@ -0,0 +1,83 @@
+// Package offsetstore keeps the position of consumers in data IDs, for the
+// client SDK's checkpoints and the nodes' consumer group offsets
+package offsetstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Types of offset stores
+const (
+	StoreNone   = "none"
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreConsul = "consul"
+)
+
+// Store keeps the position of each consumer in each data ID. The position
+// stored is the offset of the next event to process, one past the last
+// event processed.
+type Store interface {
+	// Load returns the stored position, with ok false when there is none
+	Load(ctx context.Context, consumer, dataID string) (offset int64, ok bool, err error)
+	// Save stores the position
+	Save(ctx context.Context, consumer, dataID string, offset int64) error
+}
+
+// New creates a store of the given type, keeping its files in dir or its
+// keys under kvPrefix in the Consul agent at consulAddr
+func New(store, dir, consulAddr, kvPrefix string) (Store, error) {
+	switch store {
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreFile:
+		return NewFileStore(dir)
+	case StoreConsul:
+		consulConfig := api.DefaultConfig()
+		consulConfig.Address = consulAddr
+		consulClient, err := api.NewClient(consulConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Consul client: %w", err)
+		}
+		return NewConsulStore(consulClient, kvPrefix), nil
+	default:
+		return nil, fmt.Errorf("unknown offset store: %s", store)
+	}
+}
+
+// key returns the key of an offset, <consumer>/<data ID> with both escaped
+// so that a '/' in either can't make two offsets share a key
+func key(consumer, dataID string) string {
+	return url.PathEscape(consumer) + "/" + url.PathEscape(dataID)
+}
+
+// MemoryStore keeps offsets in process memory. They survive reconnects but
+// not restarts.
+type MemoryStore struct {
+	offsets map[string]int64
+	mu      sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{offsets: make(map[string]int64)}
+}
+
+func (m *MemoryStore) Load(ctx context.Context, consumer, dataID string) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	offset, ok := m.offsets[key(consumer, dataID)]
+	return offset, ok, nil
+}
+
+func (m *MemoryStore) Save(ctx context.Context, consumer, dataID string, offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offsets[key(consumer, dataID)] = offset
+	return nil
+}
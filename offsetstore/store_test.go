@@ -0,0 +1,32 @@
+package offsetstore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryStoreKeysDoNotCollide(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	tests := []struct {
+		consumer string
+		dataID   string
+		offset   int64
+	}{
+		{consumer: "a/b", dataID: "c", offset: 1},
+		{consumer: "a", dataID: "b/c", offset: 2},
+		{consumer: "a%2Fb", dataID: "c", offset: 3},
+	}
+	for _, tt := range tests {
+		if err := store.Save(ctx, tt.consumer, tt.dataID, tt.offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		offset, ok, err := store.Load(ctx, tt.consumer, tt.dataID)
+		if err != nil || !ok || offset != tt.offset {
+			t.Errorf("Load(%q, %q) = %d, %v, %v, want %d, true, nil", tt.consumer, tt.dataID, offset, ok, err, tt.offset)
+		}
+	}
+}
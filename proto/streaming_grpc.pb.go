@@ -19,8 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Gateway_GetNodeForData_FullMethodName = "/streaming.Gateway/GetNodeForData"
-	Gateway_RegisterNode_FullMethodName   = "/streaming.Gateway/RegisterNode"
+	Gateway_GetNodeForData_FullMethodName   = "/streaming.Gateway/GetNodeForData"
+	Gateway_RegisterNode_FullMethodName     = "/streaming.Gateway/RegisterNode"
+	Gateway_UnregisterNode_FullMethodName   = "/streaming.Gateway/UnregisterNode"
+	Gateway_ListNodesForData_FullMethodName = "/streaming.Gateway/ListNodesForData"
+	Gateway_ListDataForNode_FullMethodName  = "/streaming.Gateway/ListDataForNode"
+	Gateway_Heartbeat_FullMethodName        = "/streaming.Gateway/Heartbeat"
 )
 
 // GatewayClient is the client API for Gateway service.
@@ -33,6 +37,14 @@ type GatewayClient interface {
 	GetNodeForData(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*GetNodeResponse, error)
 	// RegisterNode registers a node for a specific data ID
 	RegisterNode(ctx context.Context, in *RegisterNodeRequest, opts ...grpc.CallOption) (*RegisterNodeResponse, error)
+	// UnregisterNode removes a node from the nodes serving a data ID
+	UnregisterNode(ctx context.Context, in *UnregisterNodeRequest, opts ...grpc.CallOption) (*UnregisterNodeResponse, error)
+	// ListNodesForData lists the nodes registered for a data ID
+	ListNodesForData(ctx context.Context, in *ListNodesForDataRequest, opts ...grpc.CallOption) (*ListNodesForDataResponse, error)
+	// ListDataForNode lists the data IDs a node is registered for
+	ListDataForNode(ctx context.Context, in *ListDataForNodeRequest, opts ...grpc.CallOption) (*ListDataForNodeResponse, error)
+	// Heartbeat renews the lease keeping a node's registrations alive
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
 }
 
 type gatewayClient struct {
@@ -63,6 +75,46 @@ func (c *gatewayClient) RegisterNode(ctx context.Context, in *RegisterNodeReques
 	return out, nil
 }
 
+func (c *gatewayClient) UnregisterNode(ctx context.Context, in *UnregisterNodeRequest, opts ...grpc.CallOption) (*UnregisterNodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnregisterNodeResponse)
+	err := c.cc.Invoke(ctx, Gateway_UnregisterNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) ListNodesForData(ctx context.Context, in *ListNodesForDataRequest, opts ...grpc.CallOption) (*ListNodesForDataResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListNodesForDataResponse)
+	err := c.cc.Invoke(ctx, Gateway_ListNodesForData_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) ListDataForNode(ctx context.Context, in *ListDataForNodeRequest, opts ...grpc.CallOption) (*ListDataForNodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDataForNodeResponse)
+	err := c.cc.Invoke(ctx, Gateway_ListDataForNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, Gateway_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GatewayServer is the server API for Gateway service.
 // All implementations must embed UnimplementedGatewayServer
 // for forward compatibility.
@@ -73,6 +125,14 @@ type GatewayServer interface {
 	GetNodeForData(context.Context, *GetNodeRequest) (*GetNodeResponse, error)
 	// RegisterNode registers a node for a specific data ID
 	RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error)
+	// UnregisterNode removes a node from the nodes serving a data ID
+	UnregisterNode(context.Context, *UnregisterNodeRequest) (*UnregisterNodeResponse, error)
+	// ListNodesForData lists the nodes registered for a data ID
+	ListNodesForData(context.Context, *ListNodesForDataRequest) (*ListNodesForDataResponse, error)
+	// ListDataForNode lists the data IDs a node is registered for
+	ListDataForNode(context.Context, *ListDataForNodeRequest) (*ListDataForNodeResponse, error)
+	// Heartbeat renews the lease keeping a node's registrations alive
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	mustEmbedUnimplementedGatewayServer()
 }
 
@@ -89,6 +149,18 @@ func (UnimplementedGatewayServer) GetNodeForData(context.Context, *GetNodeReques
 func (UnimplementedGatewayServer) RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RegisterNode not implemented")
 }
+func (UnimplementedGatewayServer) UnregisterNode(context.Context, *UnregisterNodeRequest) (*UnregisterNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnregisterNode not implemented")
+}
+func (UnimplementedGatewayServer) ListNodesForData(context.Context, *ListNodesForDataRequest) (*ListNodesForDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodesForData not implemented")
+}
+func (UnimplementedGatewayServer) ListDataForNode(context.Context, *ListDataForNodeRequest) (*ListDataForNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDataForNode not implemented")
+}
+func (UnimplementedGatewayServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedGatewayServer) mustEmbedUnimplementedGatewayServer() {}
 func (UnimplementedGatewayServer) testEmbeddedByValue()                 {}
 
@@ -146,6 +218,78 @@ func _Gateway_RegisterNode_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Gateway_UnregisterNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnregisterNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).UnregisterNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_UnregisterNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).UnregisterNode(ctx, req.(*UnregisterNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_ListNodesForData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodesForDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ListNodesForData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ListNodesForData_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ListNodesForData(ctx, req.(*ListNodesForDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_ListDataForNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDataForNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ListDataForNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ListDataForNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ListDataForNode(ctx, req.(*ListDataForNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Gateway_ServiceDesc is the grpc.ServiceDesc for Gateway service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,13 +305,32 @@ var Gateway_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterNode",
 			Handler:    _Gateway_RegisterNode_Handler,
 		},
+		{
+			MethodName: "UnregisterNode",
+			Handler:    _Gateway_UnregisterNode_Handler,
+		},
+		{
+			MethodName: "ListNodesForData",
+			Handler:    _Gateway_ListNodesForData_Handler,
+		},
+		{
+			MethodName: "ListDataForNode",
+			Handler:    _Gateway_ListDataForNode_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Gateway_Heartbeat_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "streaming.proto",
 }
 
 const (
-	Node_StreamData_FullMethodName = "/streaming.Node/StreamData"
+	Node_StreamData_FullMethodName   = "/streaming.Node/StreamData"
+	Node_Publish_FullMethodName      = "/streaming.Node/Publish"
+	Node_PublishBatch_FullMethodName = "/streaming.Node/PublishBatch"
+	Node_CommitOffset_FullMethodName = "/streaming.Node/CommitOffset"
 )
 
 // NodeClient is the client API for Node service.
@@ -178,6 +341,12 @@ const (
 type NodeClient interface {
 	// StreamData streams data chunks to the client
 	StreamData(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DataChunk], error)
+	// Publish appends a stream of events and returns their offsets once the stream is closed
+	Publish(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishResponse], error)
+	// PublishBatch appends a batch of events and returns their offsets
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	// CommitOffset records the last event a consumer group processed
+	CommitOffset(ctx context.Context, in *CommitOffsetRequest, opts ...grpc.CallOption) (*CommitOffsetResponse, error)
 }
 
 type nodeClient struct {
@@ -207,6 +376,39 @@ func (c *nodeClient) StreamData(ctx context.Context, in *StreamRequest, opts ...
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Node_StreamDataClient = grpc.ServerStreamingClient[DataChunk]
 
+func (c *nodeClient) Publish(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Node_ServiceDesc.Streams[1], Node_Publish_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PublishRequest, PublishResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Node_PublishClient = grpc.ClientStreamingClient[PublishRequest, PublishResponse]
+
+func (c *nodeClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishResponse)
+	err := c.cc.Invoke(ctx, Node_PublishBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) CommitOffset(ctx context.Context, in *CommitOffsetRequest, opts ...grpc.CallOption) (*CommitOffsetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CommitOffsetResponse)
+	err := c.cc.Invoke(ctx, Node_CommitOffset_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // NodeServer is the server API for Node service.
 // All implementations must embed UnimplementedNodeServer
 // for forward compatibility.
@@ -215,6 +417,12 @@ type Node_StreamDataClient = grpc.ServerStreamingClient[DataChunk]
 type NodeServer interface {
 	// StreamData streams data chunks to the client
 	StreamData(*StreamRequest, grpc.ServerStreamingServer[DataChunk]) error
+	// Publish appends a stream of events and returns their offsets once the stream is closed
+	Publish(grpc.ClientStreamingServer[PublishRequest, PublishResponse]) error
+	// PublishBatch appends a batch of events and returns their offsets
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishResponse, error)
+	// CommitOffset records the last event a consumer group processed
+	CommitOffset(context.Context, *CommitOffsetRequest) (*CommitOffsetResponse, error)
 	mustEmbedUnimplementedNodeServer()
 }
 
@@ -228,6 +436,15 @@ type UnimplementedNodeServer struct{}
 func (UnimplementedNodeServer) StreamData(*StreamRequest, grpc.ServerStreamingServer[DataChunk]) error {
 	return status.Errorf(codes.Unimplemented, "method StreamData not implemented")
 }
+func (UnimplementedNodeServer) Publish(grpc.ClientStreamingServer[PublishRequest, PublishResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedNodeServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
+func (UnimplementedNodeServer) CommitOffset(context.Context, *CommitOffsetRequest) (*CommitOffsetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitOffset not implemented")
+}
 func (UnimplementedNodeServer) mustEmbedUnimplementedNodeServer() {}
 func (UnimplementedNodeServer) testEmbeddedByValue()              {}
 
@@ -260,19 +477,76 @@ func _Node_StreamData_Handler(srv interface{}, stream grpc.ServerStream) error {
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Node_StreamDataServer = grpc.ServerStreamingServer[DataChunk]
 
+func _Node_Publish_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(NodeServer).Publish(&grpc.GenericServerStream[PublishRequest, PublishResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Node_PublishServer = grpc.ClientStreamingServer[PublishRequest, PublishResponse]
+
+func _Node_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Node_PublishBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_CommitOffset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitOffsetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).CommitOffset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Node_CommitOffset_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).CommitOffset(ctx, req.(*CommitOffsetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Node_ServiceDesc is the grpc.ServiceDesc for Node service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Node_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "streaming.Node",
 	HandlerType: (*NodeServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PublishBatch",
+			Handler:    _Node_PublishBatch_Handler,
+		},
+		{
+			MethodName: "CommitOffset",
+			Handler:    _Node_CommitOffset_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "StreamData",
 			Handler:       _Node_StreamData_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Publish",
+			Handler:       _Node_Publish_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "streaming.proto",
 }